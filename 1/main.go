@@ -47,83 +47,384 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math"
+	"math/big"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
+// above this many entries a recursive search gets too slow, so split the set in half instead
+const meetInTheMiddleK = 4
+
+// exit status when the search completes but nothing reaches the target
+const exitNoCombination = 3
+
+// amounts with more fractional digits than this are rejected rather than scaled
+const maxDecimals = 18
+
 func main() {
 
-	input, err := ioutil.ReadFile("input.txt")
+	k := flag.Int("k", 0, "number of entries that must sum to the target (default: solve both puzzle parts)")
+	subset := flag.Bool("subset", false, "find the smallest set of entries of any size that reaches the target")
+	maxTarget := flag.Int("maxtarget", 1<<16, "largest target the subset search will build a table for")
+	all := flag.Bool("all", false, "list every distinct combination that reaches the target, not just the first")
+	inputPath := flag.String("input", "input.txt", "expense report to read")
+	column := flag.String("column", "0", "CSV column holding the amount, by index or by header name")
+	header := flag.Bool("header", false, "the first CSV row is a header")
+	targetStr := flag.String("target", "2020", "amount the entries must sum to")
+	flag.Parse()
+
+	input, err := os.Open(*inputPath)
+	if err != nil {
+		panic(err)
+	}
+	defer input.Close()
+
+	amounts, err := readAmounts(input, *column, *header)
 	if err != nil {
 		panic(err)
 	}
 
-	rows := csv.NewReader(strings.NewReader(string(input)))
+	targetAmount, err := parseAmount(*targetStr)
+	if err != nil {
+		panic(err)
+	}
+
+	// the searches work on whole numbers, so scale every amount to the smallest unit in use
+	arr, target, decimals, err := toFixedPoint(amounts, targetAmount)
+	if err != nil {
+		panic(err)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(arr)))
+
+	if *subset {
+		result, err := findMinSubset(arr, target, *maxTarget)
+		if err != nil {
+			panic(err)
+		}
+		if result == nil {
+			fmt.Printf("No combination found: no subset of entries sums to %s\n", *targetStr)
+			os.Exit(exitNoCombination)
+		}
+		printSlice(result, decimals)
+		fmt.Printf("%s\n", formatProduct(result, decimals))
+		return
+	}
+
+	ks := []int{2, 3}
+	if *k > 0 {
+		ks = []int{*k}
+	}
+
+	found := true
+	for _, n := range ks {
+		if *all {
+			matches := make(chan []int)
+			go findAllKSum(arr, target, n, matches)
+			count := 0
+			for match := range matches {
+				count++
+				fmt.Printf("%s %s\n", formatAmounts(match, decimals), formatProduct(match, decimals))
+			}
+			fmt.Printf("k=%d: %d combinations\n", n, count)
+			if count == 0 {
+				fmt.Printf("No combination found: no %d entries sum to %s\n", n, *targetStr)
+				found = false
+			}
+			continue
+		}
+
+		result := findKSum(arr, target, n)
+		if result == nil {
+			fmt.Printf("No combination found: no %d entries sum to %s\n", n, *targetStr)
+			found = false
+			continue
+		}
+
+		printSlice(result, decimals)
+		fmt.Printf("%s\n", formatProduct(result, decimals))
+	}
+
+	if !found {
+		os.Exit(exitNoCombination)
+	}
+
+}
+
+func readAmounts(r io.Reader, column string, header bool) ([]*big.Rat, error) {
+	rows := csv.NewReader(r)
+	rows.TrimLeadingSpace = true
 
-	var arr []int
+	col := -1
+	if header {
+		names, err := rows.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading header: %v", err)
+		}
+		for i, name := range names {
+			if strings.TrimSpace(name) == column {
+				col = i
+				break
+			}
+		}
+	}
+	if col < 0 {
+		var err error
+		col, err = strconv.Atoi(column)
+		if err != nil || col < 0 {
+			return nil, fmt.Errorf("no column %q", column)
+		}
+	}
 
+	var amounts []*big.Rat
 	for {
 		row, err := rows.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		if col >= len(row) {
+			return nil, fmt.Errorf("no column %q", column)
 		}
 
-		num, err := strconv.Atoi(row[0])
-
+		amount, err := parseAmount(row[col])
 		if err != nil {
-			panic(err)
+			line, _ := rows.FieldPos(col)
+			return nil, fmt.Errorf("line %d: %v", line, err)
 		}
+		amounts = append(amounts, amount)
+	}
+
+	return amounts, nil
+}
 
-		arr = append(arr, num)
+// parseAmount reads a decimal amount, ignoring currency symbols around it and thousands separators
+func parseAmount(s string) (*big.Rat, error) {
+	cleaned := strings.TrimFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '-' && r != '.'
+	})
+	cleaned = strings.ReplaceAll(cleaned, ",", "")
+	amount, ok := new(big.Rat).SetString(cleaned)
+	if !ok || cleaned == "" {
+		return nil, fmt.Errorf("invalid amount %q", s)
 	}
+	return amount, nil
+}
 
-	sort.Sort(sort.Reverse(sort.IntSlice(arr)))
+func toFixedPoint(amounts []*big.Rat, target *big.Rat) ([]int, int, int, error) {
+	decimals := 0
+	for _, amount := range append([]*big.Rat{target}, amounts...) {
+		d, err := decimalPlaces(amount)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if d > decimals {
+			decimals = d
+		}
+	}
 
-	result := findSum(arr, 2020)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
 
-	printSlice(result)
-	if result != nil {
-		fmt.Printf("%d\n", result[0]*result[1])
+	// bounding the total keeps every partial sum the searches build inside an int
+	total := new(big.Int).Abs(new(big.Rat).Mul(target, scale).Num())
+	arr := make([]int, len(amounts))
+	for i, amount := range amounts {
+		scaled := new(big.Rat).Mul(amount, scale).Num()
+		total.Add(total, new(big.Int).Abs(scaled))
+		arr[i] = int(scaled.Int64())
+	}
+	if total.Cmp(big.NewInt(math.MaxInt64)) > 0 || strconv.IntSize < 64 && total.Cmp(big.NewInt(math.MaxInt32)) > 0 {
+		return nil, 0, 0, errors.New("amounts too large to search without overflow")
 	}
 
-	result = findTripleSum(arr, 2020)
+	return arr, int(new(big.Rat).Mul(target, scale).Num().Int64()), decimals, nil
+}
 
-	printSlice(result)
-	if result != nil {
-		fmt.Printf("%d\n", result[0]*result[1]*result[2])
+func decimalPlaces(amount *big.Rat) (int, error) {
+	scaled := new(big.Rat).Set(amount)
+	ten := big.NewRat(10, 1)
+	for d := 0; d <= maxDecimals; d++ {
+		if scaled.IsInt() {
+			return d, nil
+		}
+		scaled.Mul(scaled, ten)
+	}
+	return 0, fmt.Errorf("amount %s has more than %d decimal places", amount.RatString(), maxDecimals)
+}
+
+func findKSum(arr []int, target int, k int) []int {
+	switch {
+	case k < 1 || k > len(arr):
+		return nil
+	case k == 1:
+		for _, num := range arr {
+			if num == target {
+				return []int{num}
+			}
+		}
+		return nil
+	case k == 2:
+		return findSum(arr, target)
+	case k < meetInTheMiddleK:
+		for i := 0; i <= len(arr)-k; i++ {
+			result := findKSum(arr[i+1:], target-arr[i], k-1)
+			if result != nil {
+				return append([]int{arr[i]}, result...)
+			}
+		}
+		return nil
 	}
 
+	return findKSumMeetInTheMiddle(arr, target, k)
 }
 
-func findTripleSum(arr []int, target int) []int {
+func findKSumMeetInTheMiddle(arr []int, target int, k int) []int {
+	// any k indices in order split into a lower half and an upper half, so we only pair up
+	// halves where every left index comes before every right index
+	half := k / 2
+	left := make(map[int][][]int)
+	combinations(len(arr), half, func(idx []int) bool {
+		sum := 0
+		for _, i := range idx {
+			sum += arr[i]
+		}
+		left[sum] = append(left[sum], append([]int(nil), idx...))
+		return true
+	})
 
-	subarr := arr[:]
-	var sum int
 	var result []int
-	for {
-		if len(arr) < 3 {
-			break
+	combinations(len(arr), k-half, func(idx []int) bool {
+		sum := 0
+		for _, i := range idx {
+			sum += arr[i]
+		}
+		for _, lidx := range left[target-sum] {
+			if lidx[len(lidx)-1] >= idx[0] {
+				continue
+			}
+			for _, i := range append(lidx, idx...) {
+				result = append(result, arr[i])
+			}
+			return false
 		}
+		return true
+	})
 
-		sum = target - subarr[0]
-		result = findSum(subarr[1:], sum)
+	return result
+}
 
-		if result != nil {
-			return []int{subarr[0], result[0], result[1]}
+// findAllKSum sends every distinct set of k values summing to target, each entry used at most once,
+// in descending lexicographic order, and closes matches when done
+func findAllKSum(arr []int, target int, k int, matches chan<- []int) {
+	defer close(matches)
+	walkKSum(arr, target, k, nil, matches)
+}
+
+func walkKSum(arr []int, target int, k int, prefix []int, matches chan<- []int) {
+	if k < 1 || k > len(arr) {
+		return
+	}
+
+	if k == 2 {
+		startInd := 0
+		endInd := len(arr) - 1
+		for startInd < endInd {
+			sum := arr[startInd] + arr[endInd]
+			if sum == target {
+				match := append(append([]int(nil), prefix...), arr[startInd], arr[endInd])
+				matches <- match
+				// skip over equal values so the same pair of values isn't reported twice
+				for startInd < endInd && arr[startInd] == match[len(match)-2] {
+					startInd++
+				}
+				for startInd < endInd && arr[endInd] == match[len(match)-1] {
+					endInd--
+				}
+			} else if sum < target {
+				endInd--
+			} else {
+				startInd++
+			}
 		}
+		return
+	}
 
-		subarr = subarr[1:]
+	for i := 0; i <= len(arr)-k; i++ {
+		if i > 0 && arr[i] == arr[i-1] {
+			continue
+		}
+		if k == 1 {
+			if arr[i] == target {
+				matches <- append(append([]int(nil), prefix...), arr[i])
+			}
+			continue
+		}
+		walkKSum(arr[i+1:], target-arr[i], k-1, append(prefix, arr[i]), matches)
+	}
+}
 
+// findMinSubset returns the fewest entries that sum to target, or nil if no subset does.
+// The search table is len(arr) x target, so target is capped by maxTarget.
+func findMinSubset(arr []int, target int, maxTarget int) ([]int, error) {
+	if target < 0 || target > maxTarget {
+		return nil, fmt.Errorf("target %d outside subset search range 0-%d", target, maxTarget)
+	}
+	for _, num := range arr {
+		if num < 0 {
+			return nil, errors.New("subset search needs non-negative entries")
+		}
 	}
 
-	return nil
+	const unreachable = -1
+	best := make([]int, target+1)
+	for i := range best {
+		best[i] = unreachable
+	}
+	best[0] = 0
+
+	// taken[i][s] records that entry i improved the best count for sum s
+	taken := make([][]bool, len(arr))
+	for i, num := range arr {
+		taken[i] = make([]bool, target+1)
+		if num == 0 {
+			continue
+		}
+		for sum := target; sum >= num; sum-- {
+			if best[sum-num] == unreachable {
+				continue
+			}
+			if best[sum] == unreachable || best[sum-num]+1 < best[sum] {
+				best[sum] = best[sum-num] + 1
+				taken[i][sum] = true
+			}
+		}
+	}
+
+	if best[target] == unreachable {
+		return nil, nil
+	}
+
+	result := []int{}
+	sum := target
+	for i := len(arr) - 1; i >= 0 && sum > 0; i-- {
+		if taken[i][sum] {
+			result = append(result, arr[i])
+			sum -= arr[i]
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(result)))
+
+	return result, nil
 }
 
 func findSum(arr []int, target int) []int {
@@ -152,6 +453,54 @@ func findSum(arr []int, target int) []int {
 
 }
 
-func printSlice(s []int) {
-	fmt.Printf("len=%d cap=%d %v\n", len(s), cap(s), s)
+// combinations calls fn with every ascending set of k indices below n until fn returns false
+func combinations(n int, k int, fn func([]int) bool) {
+	if k > n {
+		return
+	}
+	idx := make([]int, k)
+	for i := range idx {
+		idx[i] = i
+	}
+	for {
+		if !fn(idx) {
+			return
+		}
+		i := k - 1
+		for i >= 0 && idx[i] == n-k+i {
+			i--
+		}
+		if i < 0 {
+			return
+		}
+		idx[i]++
+		for j := i + 1; j < k; j++ {
+			idx[j] = idx[j-1] + 1
+		}
+	}
+}
+
+func product(s []int, decimals int) *big.Rat {
+	p := big.NewRat(1, 1)
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	for _, num := range s {
+		p.Mul(p, new(big.Rat).SetFrac(big.NewInt(int64(num)), scale))
+	}
+	return p
+}
+
+func formatProduct(s []int, decimals int) string {
+	return product(s, decimals).FloatString(decimals * len(s))
+}
+
+func formatAmounts(s []int, decimals int) string {
+	amounts := make([]string, len(s))
+	for i, num := range s {
+		amounts[i] = product([]int{num}, decimals).FloatString(decimals)
+	}
+	return "[" + strings.Join(amounts, " ") + "]"
+}
+
+func printSlice(s []int, decimals int) {
+	fmt.Printf("len=%d cap=%d %s\n", len(s), cap(s), formatAmounts(s, decimals))
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+// descending sorts a copy of nums the way main does before searching
+func descending(nums ...int) []int {
+	arr := append([]int(nil), nums...)
+	sort.Sort(sort.Reverse(sort.IntSlice(arr)))
+	return arr
+}
+
+var noSolutionCases = []struct {
+	name   string
+	arr    []int
+	target int
+	k      int
+}{
+	{"empty input", nil, 2020, 2},
+	{"empty input k=1", nil, 2020, 1},
+	{"fewer entries than k=2", []int{2020}, 2020, 2},
+	{"fewer entries than k=3", []int{1010, 1010}, 2020, 3},
+	{"fewer entries than k=4", []int{500, 500, 1020}, 2020, 4},
+	{"no pair", []int{1721, 979, 366, 299, 675}, 2021, 2},
+	{"no triple", []int{1721, 979, 366, 299, 675, 1456}, 1, 3},
+	// findTripleSum used to slice past the end of its input here instead of giving up
+	{"old triple search overrun", []int{1000, 1000, 1000}, 2020, 3},
+	{"no quadruple", []int{1, 2, 4, 8, 16, 32}, 2020, 4},
+	{"same entry twice", []int{1010, 1}, 2020, 2},
+}
+
+func TestFindKSumNoSolution(t *testing.T) {
+	for _, c := range noSolutionCases {
+		if result := findKSum(descending(c.arr...), c.target, c.k); result != nil {
+			t.Errorf("%s: findKSum(%v, %d, %d) = %v, want nil", c.name, c.arr, c.target, c.k, result)
+		}
+	}
+}
+
+func TestFindAllKSumNoSolution(t *testing.T) {
+	for _, c := range noSolutionCases {
+		matches := make(chan []int)
+		go findAllKSum(descending(c.arr...), c.target, c.k, matches)
+		for match := range matches {
+			t.Errorf("%s: findAllKSum(%v, %d, %d) sent %v, want no matches", c.name, c.arr, c.target, c.k, match)
+		}
+	}
+}
+
+func TestFindMinSubsetNoSolution(t *testing.T) {
+	cases := []struct {
+		name   string
+		arr    []int
+		target int
+	}{
+		{"empty input", nil, 2020},
+		{"all too large", []int{3000, 2500}, 2020},
+		{"odd target from even entries", []int{2, 4, 6, 1000}, 2019},
+	}
+	for _, c := range cases {
+		result, err := findMinSubset(descending(c.arr...), c.target, 1<<16)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if result != nil {
+			t.Errorf("%s: findMinSubset(%v, %d) = %v, want nil", c.name, c.arr, c.target, result)
+		}
+	}
+
+	// an empty subset reaches zero, which mustn't read as no subset
+	if result, err := findMinSubset(nil, 0, 1<<16); err != nil || result == nil || len(result) != 0 {
+		t.Errorf("findMinSubset(nil, 0) = %v, %v, want an empty subset", result, err)
+	}
+}
+
+func TestFindKSumExample(t *testing.T) {
+	arr := descending(1721, 979, 366, 299, 675, 1456)
+	cases := []struct {
+		k, product int
+	}{
+		{2, 514579},
+		{3, 241861950},
+	}
+	for _, c := range cases {
+		result := findKSum(arr, 2020, c.k)
+		product := 1
+		for _, num := range result {
+			product *= num
+		}
+		if result == nil || product != c.product {
+			t.Errorf("k=%d: findKSum = %v, want entries with product %d", c.k, result, c.product)
+		}
+	}
+}
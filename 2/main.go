@@ -50,149 +50,1045 @@ How many passwords are valid according to the new interpretation of the policies
 package main
 
 import (
-	"encoding/csv"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
-type PasswordChecker struct {
+// Rule is the "min-max c" part of a line; each policy reads it differently
+type Rule struct {
+	subject  Subject
+	min, max int
+}
+
+// Subject is what a rule looks for: a single character, a character class like [!@#] or digits,
+// or a quoted substring like 'ab'
+type Subject interface {
+	// matchAt returns how many characters of password starting at i the subject covers, or 0
+	matchAt(password Password, i int) int
+	// uses reports whether character c can be part of a match; anything else is safe filler
+	uses(c string) bool
+	// sample returns the characters of one random match
+	sample(rng *rand.Rand) []string
+	String() string
+}
+
+type charSubject string
+
+// classSubject matches one character by its first rune
+type classSubject struct {
+	text     string
+	contains func(rune) bool
+}
+
+type substringSubject struct {
+	text  string
+	chars []string
+}
+
+type Entry struct {
+	line     int
+	rule     Rule
 	password string
-	char     rune
+}
+
+// Password is a password split into characters, either runes or grapheme clusters
+type Password []string
+
+type Segmenter func(string) []string
+
+type Policy interface {
+	check(password Password) bool
+	// reason says why check fails, or is empty when it passes
+	reason(password Password) string
+}
+
+type PolicyFactory func(Rule) Policy
+
+// PasswordChecker is the sled rental rule: subject appears between min and max times
+type PasswordChecker struct {
+	subject  Subject
 	min, max int
 }
 
+// NewPasswordChecker is the toboggan rule: subject starts at exactly one of the two 0-indexed positions
 type NewPasswordChecker struct {
-	password string
-	char     rune
-	pos      [2]int
+	subject Subject
+	pos     [2]int
+}
+
+// AllOf, AnyOf and NotPolicy combine other policies, keeping their names so failures can be reported
+type AllOf struct {
+	names    []string
+	policies []Policy
+}
+
+type AnyOf struct {
+	names    []string
+	policies []Policy
+}
+
+type NotPolicy struct {
+	name   string
+	policy Policy
+}
+
+// Generator is implemented by policies that can build passwords with a chosen outcome:
+// "pass" or one of the ways the policy fails
+type Generator interface {
+	outcomes() []string
+	generate(rng *rand.Rand, outcome string) (Password, error)
+}
+
+// composite policies can say which of their sub-rules made a password fail
+type subRuleReporter interface {
+	failedSubRules(password Password) []string
+}
+
+// ParseError points at the line and 1-based column where an entry stopped matching "min-max c: password"
+type ParseError struct {
+	line, col int
+	msg       string
+}
+
+type LineResult struct {
+	entry   Entry
+	valid   []bool
+	reasons []string
+	failed  [][]string
+}
+
+type PolicyReport struct {
+	Name   string `json:"name"`
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type LineReport struct {
+	Line     int            `json:"line"`
+	Rule     string         `json:"rule"`
+	Password string         `json:"password"`
+	Policies []PolicyReport `json:"policies"`
+}
+
+var policyRegistry = map[string]PolicyFactory{}
+
+var namedClasses = map[string]func(rune) bool{
+	"digit":  unicode.IsDigit,
+	"letter": unicode.IsLetter,
+	"upper":  unicode.IsUpper,
+	"lower":  unicode.IsLower,
+	"space":  unicode.IsSpace,
+	"punct":  unicode.IsPunct,
+	"symbol": unicode.IsSymbol,
+}
+
+// generated passwords and class samples are drawn from these characters
+const generatorAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*-_=+"
+
+var generatorSubjects = []string{"digits", "upper", "[!@#]", "[a-f]", "'ab'", "'xyz'"}
+
+var errImpossibleOutcome = errors.New("outcome is impossible for this rule")
+
+var segmenters = map[string]Segmenter{
+	"rune":     splitRunes,
+	"grapheme": splitGraphemes,
+}
+
+func registerPolicy(name string, factory PolicyFactory) {
+	if _, ok := policyRegistry[name]; ok {
+		panic(fmt.Errorf("Duplicate policy: %s", name))
+	}
+	policyRegistry[name] = factory
+}
+
+func init() {
+	registerPolicy("sled", func(r Rule) Policy {
+		return PasswordChecker{r.subject, r.min, r.max}
+	})
+	registerPolicy("toboggan", func(r Rule) Policy {
+		return NewPasswordChecker{r.subject, [2]int{r.min - 1, r.max - 1}}
+	})
 }
 
 func main() {
 
-	input, err := ioutil.ReadFile("input.txt")
-	if err != nil {
-		panic(err)
+	policyNames := flag.String("policy", "sled,toboggan",
+		"comma-separated policies to count, from: "+strings.Join(registeredPolicies(), ", ")+
+			", combined with AND, OR, NOT and parentheses")
+	report := flag.String("report", "", "print a line-by-line report as text or json instead of counts")
+	inputPath := flag.String("input", "input.txt", "password database to check")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of goroutines checking lines")
+	chars := flag.String("chars", "rune", "what counts as one character for positions and counts: rune or grapheme")
+	generate := flag.Int("generate", 0, "write this many synthetic lines for a single -policy instead of checking")
+	seed := flag.Int64("seed", 1, "random seed for -generate")
+	flag.Parse()
+
+	split, ok := segmenters[*chars]
+	if !ok {
+		panic(fmt.Errorf("Unknown character mode: %s", *chars))
 	}
 
-	rows := csv.NewReader(strings.NewReader(string(input)))
-	rows.Comma = ':'
-	rows.TrimLeadingSpace = true
+	if *workers < 1 {
+		panic(fmt.Errorf("Need at least one worker, got %d", *workers))
+	}
 
-	var arr []PasswordChecker
-	var arr2 []NewPasswordChecker
+	if *report != "" && *report != "text" && *report != "json" {
+		panic(fmt.Errorf("Unknown report format: %s", *report))
+	}
 
-	for {
-		row, err := rows.Read()
-		if err == io.EOF {
-			break
-		}
+	var names []string
+	var factories []PolicyFactory
+	for _, expr := range strings.Split(*policyNames, ",") {
+		name, factory, err := parsePolicy(expr)
 		if err != nil {
 			panic(err)
 		}
-
-		char, min, max := parseRule(row[0])
-		password := row[1]
-
-		arr = append(arr, PasswordChecker{password, char, min, max})
-		arr2 = append(arr2, NewPasswordChecker{password, char, [2]int{min - 1, max - 1}})
+		names = append(names, name)
+		factories = append(factories, factory)
 	}
 
-	countChan := make(chan bool, len(arr))
-	newCountChan := make(chan bool, len(arr))
+	if *generate > 0 {
+		if len(factories) != 1 {
+			panic(fmt.Errorf("Generate needs exactly one policy, got %d", len(factories)))
+		}
+		counts, err := generateDatabase(os.Stdout, factories[0], *generate, split, rand.New(rand.NewSource(*seed)))
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d valid of %d\n", names[0], counts["pass"], *generate)
+		var outcomes []string
+		for outcome := range counts {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			if outcome != "pass" {
+				fmt.Fprintf(os.Stderr, "  %s: %d\n", outcome, counts[outcome])
+			}
+		}
+		return
+	}
 
-	validCount := 0
-	newValidCount := 0
-	var wg1, wg2 sync.WaitGroup
-	wg1.Add(len(arr))
-	wg2.Add(len(arr))
-	for i := 0; i < len(arr); i++ {
-		go arr[i].check(countChan, &wg1)
-		go arr2[i].check(newCountChan, &wg2)
+	input, err := os.Open(*inputPath)
+	if err != nil {
+		panic(err)
 	}
+	defer input.Close()
 
+	resultChan := make(chan LineResult, *workers)
+	errChan := make(chan []error, 1)
 	go func() {
-		wg1.Wait()
-		close(countChan)
+		errChan <- validate(input, factories, split, *workers, resultChan)
 	}()
 
-	go func() {
-		wg2.Wait()
-		close(newCountChan)
+	// parse errors are only known once the whole input has streamed through
+	defer func() {
+		parseErrors := <-errChan
+		for _, err := range parseErrors {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(parseErrors) > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped %d malformed lines\n", len(parseErrors))
+		}
 	}()
 
-	var wgTop sync.WaitGroup
-	wgTop.Add(2)
-	go func() {
-		defer wgTop.Done()
-		for result := range countChan {
-			if result {
-				validCount++
+	if *report != "" {
+		var results []LineResult
+		for result := range resultChan {
+			results = append(results, result)
+		}
+		sort.Slice(results, func(i, j int) bool { return results[i].entry.line < results[j].entry.line })
+		printReport(results, names, *report)
+		return
+	}
+
+	validCounts := make([]int, len(factories))
+	subRuleFailures := make([]map[string]int, len(factories))
+	for i := range subRuleFailures {
+		subRuleFailures[i] = make(map[string]int)
+	}
+	for result := range resultChan {
+		for i, valid := range result.valid {
+			if valid {
+				validCounts[i]++
+			}
+			for _, subRule := range result.failed[i] {
+				subRuleFailures[i][subRule]++
 			}
 		}
-		fmt.Printf("part 1: %d\n", validCount)
-	}()
+	}
 
-	go func() {
-		defer wgTop.Done()
-		for result := range newCountChan {
-			if result {
-				newValidCount++
+	for i, name := range names {
+		fmt.Printf("%s: %d\n", name, validCounts[i])
+		var subRules []string
+		for subRule := range subRuleFailures[i] {
+			subRules = append(subRules, subRule)
+		}
+		sort.Strings(subRules)
+		for _, subRule := range subRules {
+			fmt.Printf("  failed %s: %d\n", subRule, subRuleFailures[i][subRule])
+		}
+	}
+}
+
+func registeredPolicies() []string {
+	var names []string
+	for name := range policyRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validate streams entries from r through a fixed pool of workers, sending one result per line
+// and closing results once every line is checked. It returns the lines that failed to parse.
+func validate(r io.Reader, factories []PolicyFactory, split Segmenter, workers int, results chan<- LineResult) []error {
+	entries := make(chan Entry, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for entry := range entries {
+				results <- checkEntry(entry, factories, split)
 			}
+		}()
+	}
+
+	parseErrors := scanEntries(r, split, entries)
+	wg.Wait()
+	close(results)
+
+	return parseErrors
+}
+
+func scanEntries(r io.Reader, split Segmenter, entries chan<- Entry) []error {
+	defer close(entries)
+	var parseErrors []error
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
 		}
-		fmt.Printf("part 2: %d\n", newValidCount)
-	}()
+		entry, err := parseEntry(line, scanner.Text(), split)
+		if err != nil {
+			parseErrors = append(parseErrors, err)
+			continue
+		}
+		entries <- entry
+	}
+	if err := scanner.Err(); err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+	return parseErrors
+}
+
+func checkEntry(entry Entry, factories []PolicyFactory, split Segmenter) LineResult {
+	result := LineResult{entry, make([]bool, len(factories)), make([]string, len(factories)), make([][]string, len(factories))}
+	password := Password(split(entry.password))
+	for i, factory := range factories {
+		policy := factory(entry.rule)
+		result.valid[i] = policy.check(password)
+		result.reasons[i] = policy.reason(password)
+		if reporter, ok := policy.(subRuleReporter); ok && !result.valid[i] {
+			result.failed[i] = reporter.failedSubRules(password)
+		}
+	}
+	return result
+}
+
+func printReport(results []LineResult, names []string, format string) {
+	reports := make([]LineReport, len(results))
+	for i, result := range results {
+		reports[i] = LineReport{result.entry.line, result.entry.rule.String(), result.entry.password, nil}
+		for j, name := range names {
+			reports[i].Policies = append(reports[i].Policies, PolicyReport{name, result.valid[j], result.reasons[j]})
+		}
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	for _, report := range reports {
+		fmt.Printf("%d: %s: %s\n", report.Line, report.Rule, report.Password)
+		for _, policy := range report.Policies {
+			if policy.Valid {
+				fmt.Printf("  %s: pass\n", policy.Name)
+			} else {
+				fmt.Printf("  %s: fail (%s)\n", policy.Name, policy.Reason)
+			}
+		}
+	}
+}
+
+// generateDatabase writes n lines in the input.txt format for a policy, aiming each password at a
+// random outcome, and returns how many lines were generated for each outcome
+func generateDatabase(w io.Writer, factory PolicyFactory, n int, split Segmenter, rng *rand.Rand) (map[string]int, error) {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		var line string
+		var outcome string
+		for attempt := 0; line == ""; attempt++ {
+			if attempt == 100 {
+				return nil, fmt.Errorf("Couldn't generate a %q password", outcome)
+			}
+			ruleText := randomRuleText(rng)
+			entry, err := parseEntry(i+1, ruleText+": x", split)
+			if err != nil {
+				return nil, err
+			}
+			generator, ok := factory(entry.rule).(Generator)
+			if !ok {
+				return nil, errors.New("Policy can't generate passwords")
+			}
+			// half the lines pass, the rest are split between the ways to fail
+			if outcome == "" {
+				outcome = "pass"
+				if failures := generator.outcomes()[1:]; rng.Intn(2) == 0 {
+					outcome = failures[rng.Intn(len(failures))]
+				}
+			}
+			password, err := generator.generate(rng, outcome)
+			if err == errImpossibleOutcome {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			line = ruleText + ": " + strings.Join(password, "")
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return nil, err
+		}
+		counts[outcome]++
+	}
+	return counts, nil
+}
 
-	wgTop.Wait()
+func randomRuleText(rng *rand.Rand) string {
+	min := 1 + rng.Intn(5)
+	max := min + 1 + rng.Intn(6)
+	subject := string(rune('a' + rng.Intn(26)))
+	if rng.Intn(3) == 0 {
+		subject = generatorSubjects[rng.Intn(len(generatorSubjects))]
+	}
+	return fmt.Sprintf("%d-%d %s", min, max, subject)
 }
 
-func parseRule(rule string) (rune, int, int) {
-	var min, max int
-	var err error
-	rules := strings.Split(rule, " ")
-	minmax := strings.Split(rules[0], "-")
-	min, err = strconv.Atoi(minmax[0])
+func fillerChars(subject Subject) []string {
+	var filler []string
+	for _, r := range generatorAlphabet {
+		if !subject.uses(string(r)) {
+			filler = append(filler, string(r))
+		}
+	}
+	return filler
+}
+
+// policyParser reads expressions like "sled AND NOT (toboggan OR sled)"; NOT binds tightest, then AND, then OR
+type policyParser struct {
+	tokens []string
+	pos    int
+}
+
+func parsePolicy(expr string) (string, PolicyFactory, error) {
+	expr = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr)
+	p := &policyParser{tokens: strings.Fields(expr)}
+	name, factory, err := p.parseOr()
 	if err != nil {
-		panic(err)
+		return "", nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return "", nil, fmt.Errorf("Unexpected %q in policy", p.tokens[p.pos])
+	}
+	return name, factory, nil
+}
+
+func (p *policyParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
 	}
-	max, err = strconv.Atoi(minmax[1])
+	return ""
+}
+
+func (p *policyParser) parseOr() (string, PolicyFactory, error) {
+	names, factories, err := p.parseList("OR", p.parseAnd)
 	if err != nil {
-		panic(err)
+		return "", nil, err
 	}
-	return rune(rules[1][0]), min, max
+	if len(names) == 1 {
+		return names[0], factories[0], nil
+	}
+	return strings.Join(names, " OR "), func(r Rule) Policy {
+		return AnyOf{names, buildPolicies(factories, r)}
+	}, nil
 }
 
-func (pc PasswordChecker) check(c chan bool, wg *sync.WaitGroup) {
-	defer wg.Done()
-	count := strings.Count(pc.password, string(pc.char))
-	if count >= pc.min && count <= pc.max {
-		c <- true
-	} else {
-		c <- false
+func (p *policyParser) parseAnd() (string, PolicyFactory, error) {
+	names, factories, err := p.parseList("AND", p.parseNot)
+	if err != nil {
+		return "", nil, err
 	}
+	if len(names) == 1 {
+		return names[0], factories[0], nil
+	}
+	return strings.Join(names, " AND "), func(r Rule) Policy {
+		return AllOf{names, buildPolicies(factories, r)}
+	}, nil
+}
 
+func (p *policyParser) parseList(op string, parseOperand func() (string, PolicyFactory, error)) ([]string, []PolicyFactory, error) {
+	var names []string
+	var factories []PolicyFactory
+	for {
+		name, factory, err := parseOperand()
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		factories = append(factories, factory)
+		if !strings.EqualFold(p.peek(), op) {
+			return names, factories, nil
+		}
+		p.pos++
+	}
 }
 
-func (pc NewPasswordChecker) check(c chan bool, wg *sync.WaitGroup) {
-	defer wg.Done()
-	// password is too short
-	if len(pc.password) < pc.pos[0] {
-		c <- false
-		return
+func (p *policyParser) parseNot() (string, PolicyFactory, error) {
+	tok := p.peek()
+	switch {
+	case strings.EqualFold(tok, "NOT"):
+		p.pos++
+		name, factory, err := p.parseNot()
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + name, func(r Rule) Policy {
+			return NotPolicy{name, factory(r)}
+		}, nil
+	case tok == "(":
+		p.pos++
+		name, factory, err := p.parseOr()
+		if err != nil {
+			return "", nil, err
+		}
+		if p.peek() != ")" {
+			return "", nil, fmt.Errorf("Missing ) in policy")
+		}
+		p.pos++
+		if strings.Contains(name, " ") {
+			name = "(" + name + ")"
+		}
+		return name, factory, nil
+	case tok == "":
+		return "", nil, fmt.Errorf("Policy ended early")
 	}
-	// short enough for only first posiion
-	if len(pc.password) < pc.pos[1] {
-		c <- rune(pc.password[pc.pos[0]]) == pc.char
-		return
+
+	factory, ok := policyRegistry[tok]
+	if !ok {
+		return "", nil, fmt.Errorf("Unknown policy: %s", tok)
+	}
+	p.pos++
+	return tok, factory, nil
+}
+
+func buildPolicies(factories []PolicyFactory, r Rule) []Policy {
+	policies := make([]Policy, len(factories))
+	for i, factory := range factories {
+		policies[i] = factory(r)
+	}
+	return policies
+}
+
+// parseEntry reads "min-max c: password". The password is the rest of the line after ": ",
+// so it may itself contain colons and spaces.
+func parseEntry(line int, text string, split Segmenter) (Entry, error) {
+	pos := 0
+	fail := func(msg string, args ...interface{}) (Entry, error) {
+		return Entry{}, &ParseError{line, utf8.RuneCountInString(text[:pos]) + 1, fmt.Sprintf(msg, args...)}
+	}
+	number := func() (int, bool) {
+		start := pos
+		for pos < len(text) && text[pos] >= '0' && text[pos] <= '9' {
+			pos++
+		}
+		n, err := strconv.Atoi(text[start:pos])
+		if err != nil {
+			pos = start
+			return 0, false
+		}
+		return n, true
+	}
+
+	for i, r := range text {
+		if _, size := utf8.DecodeRuneInString(text[i:]); r == utf8.RuneError && size == 1 {
+			pos = i
+			return fail("invalid UTF-8")
+		}
+	}
+
+	for pos < len(text) && (text[pos] == ' ' || text[pos] == '\t') {
+		pos++
+	}
+	min, ok := number()
+	if !ok {
+		return fail("expected a number")
+	}
+	if pos >= len(text) || text[pos] != '-' {
+		return fail("expected '-' after %d", min)
+	}
+	pos++
+	max, ok := number()
+	if !ok {
+		return fail("expected a number after '-'")
+	}
+	if pos >= len(text) || text[pos] != ' ' {
+		return fail("expected a space before the policy subject")
+	}
+	for pos < len(text) && text[pos] == ' ' {
+		pos++
+	}
+
+	var subject Subject
+	start := pos
+	word := pos
+	for word < len(text) && text[word] >= 'a' && text[word] <= 'z' {
+		word++
+	}
+	switch {
+	case pos < len(text) && text[pos] == '[':
+		if pos+2 > len(text) {
+			return fail("unterminated character class")
+		}
+		end := strings.IndexByte(text[pos+2:], ']')
+		if end < 0 {
+			return fail("unterminated character class")
+		}
+		pos += end + 3
+		class, err := parseClass(text[start+1 : pos-1])
+		if err != nil {
+			return fail("%v", err)
+		}
+		subject = classSubject{text[start:pos], class}
+	case pos < len(text) && text[pos] == '\'':
+		end := strings.IndexByte(text[pos+1:], '\'')
+		if end < 1 {
+			return fail("expected a non-empty quoted substring")
+		}
+		pos += end + 2
+		subject = substringSubject{text[start:pos], split(text[start+1 : pos-1])}
+	case word-pos > 1 && word < len(text) && text[word] == ':':
+		name := text[pos:word]
+		class, ok := namedClasses[strings.TrimSuffix(name, "s")]
+		if !ok {
+			return fail("unknown character class %q", name)
+		}
+		pos = word
+		subject = classSubject{name, class}
+	default:
+		rest := split(text[pos:])
+		if len(rest) == 0 || rest[0] == ":" {
+			return fail("expected a policy subject")
+		}
+		pos += len(rest[0])
+		subject = charSubject(rest[0])
+	}
+
+	if pos >= len(text) || text[pos] != ':' {
+		return fail("expected ':' after policy subject %s", subject)
+	}
+	pos++
+	if pos < len(text) && text[pos] == ' ' {
+		pos++
+	}
+	if pos >= len(text) {
+		return fail("missing password")
+	}
+
+	return Entry{line, Rule{subject, min, max}, text[pos:]}, nil
+}
+
+// parseClass reads the inside of [...]: single characters and a-z style ranges, negated by a leading ^.
+// A ] can't appear inside the class, but one placed first is taken literally.
+func parseClass(members string) (func(rune) bool, error) {
+	negate := strings.HasPrefix(members, "^") && len(members) > 1
+	if negate {
+		members = members[1:]
+	}
+	runes := []rune(members)
+	var ranges [][2]rune
+	for i := 0; i < len(runes); i++ {
+		lo, hi := runes[i], runes[i]
+		if i+2 < len(runes) && runes[i+1] == '-' {
+			hi = runes[i+2]
+			i += 2
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("range %c-%c is out of order", lo, hi)
+		}
+		ranges = append(ranges, [2]rune{lo, hi})
+	}
+	return func(r rune) bool {
+		for _, rg := range ranges {
+			if r >= rg[0] && r <= rg[1] {
+				return !negate
+			}
+		}
+		return negate
+	}, nil
+}
+
+func splitRunes(s string) []string {
+	chars := make([]string, 0, len(s))
+	// slice rather than convert each rune, so invalid bytes come through as they were instead of U+FFFD
+	for i := 0; i < len(s); {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		chars = append(chars, s[i:i+size])
+		i += size
+	}
+	return chars
+}
+
+// splitGraphemes approximates extended grapheme clusters: combining marks, variation selectors,
+// emoji modifiers and zero-width-joined sequences stay with the character before them, regional
+// indicators pair up into flags and CRLF stays together
+func splitGraphemes(s string) []string {
+	var chars []string
+	start := 0
+	var prev rune = -1
+	regionalIndicators := 0
+	for i, r := range s {
+		extends := unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+			r == zeroWidthJoiner || (r >= 0x1F3FB && r <= 0x1F3FF) ||
+			prev == zeroWidthJoiner || (prev == '\r' && r == '\n') ||
+			(isRegionalIndicator(r) && regionalIndicators%2 == 1)
+		if i > 0 && !extends {
+			chars = append(chars, s[start:i])
+			start = i
+			regionalIndicators = 0
+		}
+		if isRegionalIndicator(r) {
+			regionalIndicators++
+		}
+		prev = r
+	}
+	if start < len(s) {
+		chars = append(chars, s[start:])
+	}
+	return chars
+}
+
+const zeroWidthJoiner = '\u200d'
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.line, e.col, e.msg)
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%d-%d %s", r.min, r.max, r.subject)
+}
+
+func (c charSubject) matchAt(password Password, i int) int {
+	if i >= 0 && i < len(password) && password[i] == string(c) {
+		return 1
+	}
+	return 0
+}
+
+func (c charSubject) uses(char string) bool {
+	return char == string(c)
+}
+
+func (c charSubject) sample(rng *rand.Rand) []string {
+	return []string{string(c)}
+}
+
+func (c charSubject) String() string {
+	return string(c)
+}
+
+func (c classSubject) matchAt(password Password, i int) int {
+	if i < 0 || i >= len(password) {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(password[i])
+	if c.contains(r) {
+		return 1
+	}
+	return 0
+}
+
+func (c classSubject) uses(char string) bool {
+	r, _ := utf8.DecodeRuneInString(char)
+	return c.contains(r)
+}
+
+func (c classSubject) sample(rng *rand.Rand) []string {
+	var members []string
+	for _, r := range generatorAlphabet {
+		if c.contains(r) {
+			members = append(members, string(r))
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return []string{members[rng.Intn(len(members))]}
+}
+
+func (c classSubject) String() string {
+	return c.text
+}
+
+func (s substringSubject) matchAt(password Password, i int) int {
+	if i < 0 || i+len(s.chars) > len(password) {
+		return 0
+	}
+	for j, c := range s.chars {
+		if password[i+j] != c {
+			return 0
+		}
+	}
+	return len(s.chars)
+}
+
+func (s substringSubject) uses(char string) bool {
+	for _, c := range s.chars {
+		if c == char {
+			return true
+		}
+	}
+	return false
+}
+
+func (s substringSubject) sample(rng *rand.Rand) []string {
+	return s.chars
+}
+
+func (s substringSubject) String() string {
+	return s.text
+}
+
+func (pc PasswordChecker) check(password Password) bool {
+	return pc.reason(password) == ""
+}
+
+func (pc PasswordChecker) reason(password Password) string {
+	// occurrences don't overlap, so 'aa' is counted twice in aaaa, like strings.Count
+	count := 0
+	for i := 0; i < len(password); {
+		if n := pc.subject.matchAt(password, i); n > 0 {
+			count++
+			i += n
+		} else {
+			i++
+		}
+	}
+	if count < pc.min {
+		return fmt.Sprintf("count %d below min %d", count, pc.min)
+	}
+	if count > pc.max {
+		return fmt.Sprintf("count %d above max %d", count, pc.max)
+	}
+	return ""
+}
+
+func (pc PasswordChecker) outcomes() []string {
+	return []string{"pass", "below min", "above max"}
+}
+
+// generate strings together the wanted number of subject matches with filler that can't match
+func (pc PasswordChecker) generate(rng *rand.Rand, outcome string) (Password, error) {
+	var count int
+	switch outcome {
+	case "pass":
+		count = pc.min + rng.Intn(pc.max-pc.min+1)
+	case "below min":
+		if pc.min == 0 {
+			return nil, errImpossibleOutcome
+		}
+		count = rng.Intn(pc.min)
+	case "above max":
+		count = pc.max + 1 + rng.Intn(3)
+	default:
+		return nil, fmt.Errorf("Unknown outcome: %s", outcome)
+	}
+
+	filler := fillerChars(pc.subject)
+	if len(filler) == 0 {
+		return nil, errImpossibleOutcome
+	}
+	pad := func(password Password, n int) Password {
+		for i := 0; i < n; i++ {
+			password = append(password, filler[rng.Intn(len(filler))])
+		}
+		return password
+	}
+
+	password := pad(nil, rng.Intn(3))
+	for i := 0; i < count; i++ {
+		match := pc.subject.sample(rng)
+		if match == nil {
+			return nil, errImpossibleOutcome
+		}
+		password = pad(append(password, match...), 1+rng.Intn(2))
+	}
+	if len(password) == 0 {
+		password = pad(password, 1)
+	}
+	return password, nil
+}
+
+func (pc NewPasswordChecker) check(password Password) bool {
+	return pc.reason(password) == ""
+}
+
+func (pc NewPasswordChecker) reason(password Password) string {
+	first, second := pc.subject.matchAt(password, pc.pos[0]) > 0, pc.subject.matchAt(password, pc.pos[1]) > 0
+	if first && second {
+		return "both positions match"
+	}
+	if !first && !second {
+		return "neither position matches"
 	}
+	return ""
+}
+
+func (pc NewPasswordChecker) outcomes() []string {
+	return []string{"pass", "both positions match", "neither position matches"}
+}
+
+// generate fills a password with characters that can't match, then writes the subject at the chosen positions
+func (pc NewPasswordChecker) generate(rng *rand.Rand, outcome string) (Password, error) {
+	filler := fillerChars(pc.subject)
+	match := pc.subject.sample(rng)
+	if len(filler) == 0 || match == nil || pc.pos[0] < 0 || pc.pos[1] < 0 || pc.pos[0] == pc.pos[1] {
+		return nil, errImpossibleOutcome
+	}
+
+	var at []int
+	switch outcome {
+	case "pass":
+		at = []int{pc.pos[rng.Intn(2)]}
+	case "both positions match":
+		gap := pc.pos[1] - pc.pos[0]
+		if gap < len(match) && -gap < len(match) {
+			return nil, errImpossibleOutcome
+		}
+		at = pc.pos[:]
+	case "neither position matches":
+	default:
+		return nil, fmt.Errorf("Unknown outcome: %s", outcome)
+	}
+
+	length := pc.pos[0]
+	if pc.pos[1] > length {
+		length = pc.pos[1]
+	}
+	password := make(Password, length+len(match)+rng.Intn(4))
+	for i := range password {
+		password[i] = filler[rng.Intn(len(filler))]
+	}
+	for _, pos := range at {
+		copy(password[pos:], pc.subject.sample(rng))
+	}
+	return password, nil
+}
+
+func (p AllOf) check(password Password) bool {
+	for _, policy := range p.policies {
+		if !policy.check(password) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p AllOf) reason(password Password) string {
+	var reasons []string
+	for i, policy := range p.policies {
+		if r := policy.reason(password); r != "" {
+			reasons = append(reasons, p.names[i]+": "+r)
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func (p AllOf) failedSubRules(password Password) []string {
+	var failed []string
+	for i, policy := range p.policies {
+		if !policy.check(password) {
+			failed = append(failed, p.names[i])
+		}
+	}
+	return failed
+}
+
+func (p AnyOf) check(password Password) bool {
+	for _, policy := range p.policies {
+		if policy.check(password) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p AnyOf) reason(password Password) string {
+	if p.check(password) {
+		return ""
+	}
+	reasons := make([]string, len(p.policies))
+	for i, policy := range p.policies {
+		reasons[i] = p.names[i] + ": " + policy.reason(password)
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func (p AnyOf) failedSubRules(password Password) []string {
+	if p.check(password) {
+		return nil
+	}
+	return p.names
+}
+
+func (p NotPolicy) check(password Password) bool {
+	return !p.policy.check(password)
+}
+
+func (p NotPolicy) reason(password Password) string {
+	if p.check(password) {
+		return ""
+	}
+	return p.name + " passed"
+}
 
-	if (rune(pc.password[pc.pos[0]]) == pc.char && rune(pc.password[pc.pos[1]]) != pc.char) ||
-		(rune(pc.password[pc.pos[0]]) != pc.char && rune(pc.password[pc.pos[1]]) == pc.char) {
-		c <- true
-	} else {
-		c <- false
+func (p NotPolicy) failedSubRules(password Password) []string {
+	if p.check(password) {
+		return nil
 	}
+	return []string{p.name + " passed"}
 }
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func puzzleFactories(t testing.TB) []PolicyFactory {
+	var factories []PolicyFactory
+	for _, name := range []string{"sled", "toboggan"} {
+		_, factory, err := parsePolicy(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		factories = append(factories, factory)
+	}
+	return factories
+}
+
+func readInput(t testing.TB) string {
+	input, err := ioutil.ReadFile("input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(input)
+}
+
+// poolCounts counts valid lines per policy through the worker pool
+func poolCounts(input string, factories []PolicyFactory, split Segmenter, workers int) []int {
+	counts := make([]int, len(factories))
+	results := make(chan LineResult, workers)
+	go validate(strings.NewReader(input), factories, split, workers, results)
+	for result := range results {
+		for i, valid := range result.valid {
+			if valid {
+				counts[i]++
+			}
+		}
+	}
+	return counts
+}
+
+// perLineCounts is the original design, which read every line up front and started a goroutine
+// per line and policy, each policy with its own channel and WaitGroup
+func perLineCounts(input string, factories []PolicyFactory, split Segmenter) []int {
+	var entries []Entry
+	entryChan := make(chan Entry)
+	go scanEntries(strings.NewReader(input), split, entryChan)
+	for entry := range entryChan {
+		entries = append(entries, entry)
+	}
+
+	counts := make([]int, len(factories))
+	var wgTop sync.WaitGroup
+	wgTop.Add(len(factories))
+	for i, factory := range factories {
+		countChan := make(chan bool, len(entries))
+		var wg sync.WaitGroup
+		wg.Add(len(entries))
+		for _, entry := range entries {
+			go func(factory PolicyFactory, entry Entry) {
+				defer wg.Done()
+				countChan <- factory(entry.rule).check(split(entry.password))
+			}(factory, entry)
+		}
+		go func() {
+			wg.Wait()
+			close(countChan)
+		}()
+		go func(i int, countChan chan bool) {
+			defer wgTop.Done()
+			for valid := range countChan {
+				if valid {
+					counts[i]++
+				}
+			}
+		}(i, countChan)
+	}
+	wgTop.Wait()
+	return counts
+}
+
+func TestPoolMatchesPerLine(t *testing.T) {
+	input := readInput(t)
+	factories := puzzleFactories(t)
+	want := perLineCounts(input, factories, splitRunes)
+	if want[0] != 556 || want[1] != 605 {
+		t.Fatalf("goroutine per line counted sled %d, toboggan %d, want 556 and 605", want[0], want[1])
+	}
+	for _, workers := range []int{1, 2, 7, 64} {
+		got := poolCounts(input, factories, splitRunes, workers)
+		if got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("%d workers: counted sled %d, toboggan %d, want %d and %d", workers, got[0], got[1], want[0], want[1])
+		}
+	}
+}
+
+func BenchmarkWorkerPool(b *testing.B) {
+	input := readInput(b)
+	factories := puzzleFactories(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		poolCounts(input, factories, splitRunes, 8)
+	}
+}
+
+func BenchmarkGoroutinePerLine(b *testing.B) {
+	input := readInput(b)
+	factories := puzzleFactories(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		perLineCounts(input, factories, splitRunes)
+	}
+}
+
+func TestSegmenters(t *testing.T) {
+	cases := []struct {
+		name      string
+		text      string
+		runes     []string
+		graphemes []string
+	}{
+		{"ascii", "abc", []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{"umlauts", "müde", []string{"m", "ü", "d", "e"}, []string{"m", "ü", "d", "e"}},
+		{"precomposed é", "café", []string{"c", "a", "f", "é"}, []string{"c", "a", "f", "é"}},
+		{"decomposed é", "café", []string{"c", "a", "f", "e", "́"}, []string{"c", "a", "f", "é"}},
+		{"flags", "🇩🇪🇫🇷", []string{"🇩", "🇪", "🇫", "🇷"}, []string{"🇩🇪", "🇫🇷"}},
+		{"skin tone", "a👍🏽b", []string{"a", "👍", "🏽", "b"}, []string{"a", "👍🏽", "b"}},
+		{"zwj family", "👨‍👩‍👧x", []string{"👨", "‍", "👩", "‍", "👧", "x"}, []string{"👨‍👩‍👧", "x"}},
+		{"invalid utf-8", "a\xffb", []string{"a", "\xff", "b"}, []string{"a", "\xff", "b"}},
+		{"empty", "", []string{}, nil},
+	}
+	for _, c := range cases {
+		for mode, want := range map[string][]string{"rune": c.runes, "grapheme": c.graphemes} {
+			got := segmenters[mode](c.text)
+			if strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+				t.Errorf("%s, %s mode: split %q into %q, want %q", c.name, mode, c.text, got, want)
+			}
+		}
+	}
+}
+
+func TestPoliciesNonASCII(t *testing.T) {
+	cases := []struct {
+		name           string
+		line           string
+		mode           string
+		sled, toboggan bool
+	}{
+		{"umlaut counted once", "1-1 ü: müde", "rune", true, false},
+		{"umlaut at second position", "2-4 ü: müde", "rune", false, true},
+		{"multi-byte letter at first position", "1-3 é: éaa", "rune", true, true},
+		{"multi-byte letter at second position", "1-3 é: aaé", "rune", true, true},
+		{"multi-byte letter at both positions", "1-3 é: éaé", "rune", true, false},
+		{"multi-byte letter at neither position", "1-3 é: aéa", "rune", true, false},
+		{"multi-byte letter at both, graphemes", "1-3 é: éaé", "grapheme", true, false},
+		// the decomposed é is two runes, which shifts everything after it by one in rune mode
+		{"decomposed before precomposed, runes", "1-2 é: éé", "rune", true, false},
+		{"decomposed before precomposed, graphemes", "1-2 é: éé", "grapheme", true, true},
+		{"decomposed never matches precomposed", "1-1 é: é", "grapheme", false, false},
+		{"flag as a letter", "1-2 🇩🇪: 🇫🇷🇩🇪", "grapheme", true, true},
+		{"skin tone stays with its emoji", "1-3 x: 👍🏽ax", "grapheme", true, true},
+		{"skin tone shifts positions in rune mode", "1-3 x: 👍🏽ax", "rune", true, false},
+		{"zwj sequence is one position", "1-2 a: 👨‍👩‍👧a", "grapheme", true, true},
+	}
+	factories := puzzleFactories(t)
+	for _, c := range cases {
+		split := segmenters[c.mode]
+		entry, err := parseEntry(1, c.line, split)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		result := checkEntry(entry, factories, split)
+		if result.valid[0] != c.sled || result.valid[1] != c.toboggan {
+			t.Errorf("%s: %q in %s mode gave sled %t, toboggan %t, want %t and %t",
+				c.name, c.line, c.mode, result.valid[0], result.valid[1], c.sled, c.toboggan)
+		}
+	}
+
+	if _, err := parseEntry(1, "1-3 a: a\xffa", splitRunes); err == nil {
+		t.Errorf("invalid UTF-8 password parsed without an error")
+	}
+}
+
+func TestParseEntryErrors(t *testing.T) {
+	cases := []struct {
+		line, msg string
+	}{
+		{"1-3 [", "unterminated character class"},
+		{"1-3 [a", "unterminated character class"},
+		{"1-3 [ab: abc", "unterminated character class"},
+		{"1-3 '': abc", "expected a non-empty quoted substring"},
+		{"1-3", "expected a space before the policy subject"},
+		{"a-3 b: abc", "expected a number"},
+	}
+	for _, c := range cases {
+		_, err := parseEntry(1, c.line, splitRunes)
+		if err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("parseEntry(%q) = %v, want an error containing %q", c.line, err, c.msg)
+		}
+	}
+}
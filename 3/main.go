@@ -82,8 +82,16 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -97,43 +105,313 @@ type MapPosition struct {
 }
 
 type SlopeResult struct {
-	slope Slope
-	trees int
+	index   int
+	slope   Slope
+	trees   int
+	steps   int
+	cost    float64
+	terrain map[byte]int
 }
 
+type Terrain struct {
+	Name string  `json:"name"`
+	Cost float64 `json:"cost"`
+}
+
+// Legend maps each map character to its terrain
+type Legend map[byte]Terrain
+
 type Map []string
 
+// BitMap packs the map into bits, one column at a time: bit row%64 of cols[col][row/64] is set
+// where there's a tree. Keeping columns contiguous lets a slope that keeps landing in the same
+// column count its trees with masked popcounts.
+type BitMap struct {
+	width, height int
+	cols          [][]uint64
+}
+
+type SlopeCounter interface {
+	countTrees(slope Slope, topology Topology) (trees int, steps int)
+}
+
+// TerrainCounter is implemented by maps that keep every square's character, not just its trees
+type TerrainCounter interface {
+	countTerrain(slope Slope, topology Topology) (terrain map[byte]int, steps int)
+}
+
+// Topology decides where a toboggan that has moved to pos (which may be off the map) really is,
+// or that the run is over. Every run also stops after maxSteps steps, or width*height if that's 0.
+type Topology struct {
+	name     string
+	locate   func(pos MapPosition, width int, height int) (MapPosition, bool)
+	maxSteps int
+}
+
+// SlopeList collects repeated -slope flags
+type SlopeList []Slope
+
+// MoveList collects repeated -move flags; a right step can be a lo:hi range
+type MoveList []Slope
+
 func main() {
 
-	input, err := ioutil.ReadFile("input.txt")
+	var slopes SlopeList
+	var moves MoveList
+	mapPath := flag.String("map", "input.txt", "map file to run the slopes over")
+	configPath := flag.String("config", "", "file with one right,down slope per line; # starts a comment")
+	flag.Var(&slopes, "slope", "right,down slope to run; repeat for more (default: the five puzzle slopes)")
+	search := flag.Bool("search", false, "rank every slope in -search-right and -search-down by trees hit")
+	rightRange := flag.String("search-right", "0:10", "lo:hi range of right steps to search")
+	downRange := flag.String("search-down", "1:4", "lo:hi range of down steps to search")
+	top := flag.Int("top", 10, "how many ranked slopes to print in search mode")
+	render := flag.String("render", "", "draw the tiled map with each slope's route, as text or ansi; "+
+		"the first slope marks O/X like the puzzle, the second o/x, later ones A/a, B/b, ... and * where routes cross")
+	pathfind := flag.Bool("pathfind", false, "find the route to the bottom that hits the fewest trees using -move steps")
+	flag.Var(&moves, "move", "right,down step the pathfinder may take, right may be a lo:hi range; "+
+		"repeat for more (default: 0:3,1)")
+	topologyName := flag.String("topology", "wrap", "how slope runs treat the map edges: wrap (tile to the sides, "+
+		"end off the top or bottom), none (end off any edge), torus (wrap every edge) or mirror (tiles alternate flipped)")
+	maxSteps := flag.Int("max-steps", 0, "stop every slope run after this many steps (default: width*height)")
+	legendPath := flag.String("legend", "", `JSON file of terrain per map character, like {"#": {"name": "tree", "cost": 1}}; `+
+		"slope runs then report their total cost and a count of each terrain")
+	bitset := flag.Bool("bitset", false, "stream the map into a packed bitset; only for slope runs and -search")
+	flag.Parse()
+
+	locate, ok := topologies[*topologyName]
+	if !ok {
+		panic(fmt.Errorf("Unknown topology: %s", *topologyName))
+	}
+	topology := Topology{*topologyName, locate, *maxSteps}
+
+	if *bitset && (*render != "" || *pathfind || *legendPath != "") {
+		panic(errors.New("-bitset only works for slope runs and -search without -legend"))
+	}
+
+	var legend Legend
+	if *legendPath != "" {
+		var err error
+		legend, err = readLegend(*legendPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if *render != "" && *render != "text" && *render != "ansi" {
+		panic(fmt.Errorf("Unknown render format: %s", *render))
+	}
+
+	// the pathfinder always wraps columns and ends past the bottom row, the way the puzzle's map works
+	if *pathfind && *topologyName != "wrap" {
+		panic(fmt.Errorf("-pathfind only works on the wrap topology, not %s", *topologyName))
+	}
+
+	if *configPath != "" {
+		fromConfig, err := readSlopeConfig(*configPath)
+		if err != nil {
+			panic(err)
+		}
+		slopes = append(fromConfig, slopes...)
+	}
+	if len(slopes) == 0 {
+		slopes = SlopeList{
+			{1, 1},
+			{3, 1},
+			{5, 1},
+			{7, 1},
+			{1, 2},
+		}
+	}
+
+	var slopeMap Map
+	var counter SlopeCounter
+	if *bitset {
+		input, err := os.Open(*mapPath)
+		if err != nil {
+			panic(err)
+		}
+		bitMap, err := readBitMap(input)
+		input.Close()
+		if err != nil {
+			panic(err)
+		}
+		counter = bitMap
+	} else {
+		var err error
+		slopeMap, err = readMapFile(*mapPath)
+		if err != nil {
+			panic(err)
+		}
+		counter = slopeMap
+		if err := slopeMap.checkLegend(legend); err != nil {
+			panic(err)
+		}
+	}
+
+	if *search {
+		candidates, err := slopesInRange(*rightRange, *downRange)
+		if err != nil {
+			panic(err)
+		}
+		results := rankSlopes(runSlopes(counter, candidates, topology, nil))
+		if *top > 0 && len(results) > *top {
+			results = results[:*top]
+		}
+		for i, result := range results {
+			fmt.Printf("%d. Slope: %d down, %d right: %d trees in %d steps\n",
+				i+1, result.slope.down, result.slope.right, result.trees, result.steps)
+		}
+		return
+	}
+
+	if *pathfind {
+		if len(moves) == 0 {
+			if err := moves.Set("0:3,1"); err != nil {
+				panic(err)
+			}
+		}
+		trees, path, ok := findFewestTrees(slopeMap, moves)
+		if !ok {
+			fmt.Println("No route reaches the bottom")
+			return
+		}
+		var steps []string
+		for i := 1; i < len(path); i++ {
+			steps = append(steps, fmt.Sprintf("%d,%d", path[i].col-path[i-1].col, path[i].row-path[i-1].row))
+		}
+		fmt.Printf("Fewest trees: %d in %d steps\n", trees, len(steps))
+		fmt.Printf("Moves: %s\n", strings.Join(steps, " "))
+		return
+	}
+
+	if *render != "" {
+		for _, slope := range slopes {
+			// these only stop at the step cap, by which point the tiles would run for miles
+			if slope.down == 0 && topology.maxSteps == 0 && (topology.name == "wrap" || topology.name == "mirror") {
+				panic(fmt.Errorf("Slope %d,%d never leaves the %s map; set -max-steps to render it", slope.right, slope.down, topology.name))
+			}
+		}
+		out := bufio.NewWriter(os.Stdout)
+		renderRoutes(out, slopeMap, slopes, topology, *render == "ansi")
+		if err := out.Flush(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if legend != nil {
+		var totalCost float64
+		for _, result := range runSlopes(counter, slopes, topology, legend) {
+			fmt.Printf("Slope: %d down, %d right: cost %g (%s)\n",
+				result.slope.down, result.slope.right, result.cost, legend.describe(result.terrain))
+			totalCost += result.cost
+		}
+		fmt.Printf("Total cost: %g\n", totalCost)
+		return
+	}
+
+	total := 1
+	for _, result := range runSlopes(counter, slopes, topology, nil) {
+		fmt.Printf("Slope: %d down, %d right: %d trees\n", result.slope.down, result.slope.right, result.trees)
+		total *= result.trees
+	}
+
+	fmt.Printf("Total trees: %d\n", total)
+}
+
+func readLegend(path string) (Legend, error) {
+	input, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	var terrains map[string]Terrain
+	if err := json.Unmarshal(input, &terrains); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
 	}
+	legend := make(Legend)
+	for char, terrain := range terrains {
+		if len(char) != 1 {
+			return nil, fmt.Errorf("%s: legend key %q should be a single character", path, char)
+		}
+		legend[char[0]] = terrain
+	}
+	return legend, nil
+}
 
+// checkLegend makes sure every square on the map has a terrain
+func (m Map) checkLegend(legend Legend) error {
+	if legend == nil {
+		return nil
+	}
+	for row, line := range m {
+		for col := 0; col < len(line); col++ {
+			if _, ok := legend[line[col]]; !ok {
+				return fmt.Errorf("Map line %d column %d: %q isn't in the legend", row+1, col+1, line[col])
+			}
+		}
+	}
+	return nil
+}
+
+// describe lists how many squares of each terrain a run crossed, by terrain name
+func (legend Legend) describe(counts map[byte]int) string {
+	byName := make(map[string]int)
+	for char, count := range counts {
+		byName[legend[char].Name] += count
+	}
+	var names []string
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %d", name, byName[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func readMapFile(path string) (Map, error) {
+	input, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return readMap(strings.NewReader(string(input)))
+}
+
+func readMap(r io.Reader) (Map, error) {
 	var slopeMap Map
-	scanner := bufio.NewScanner(strings.NewReader(string(input)))
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		slopeMap = append(slopeMap, scanner.Text())
 	}
-
-	wrapWidth := len(slopeMap[0])
-	finishLine := len(slopeMap)
-
-	slopes := []Slope{
-		{1, 1},
-		{3, 1},
-		{5, 1},
-		{7, 1},
-		{1, 2},
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
+	for len(slopeMap) > 0 && slopeMap[len(slopeMap)-1] == "" {
+		slopeMap = slopeMap[:len(slopeMap)-1]
+	}
+	if len(slopeMap) == 0 {
+		return nil, errors.New("Map is empty")
+	}
+	for i, line := range slopeMap {
+		if len(line) != len(slopeMap[0]) || len(line) == 0 {
+			return nil, fmt.Errorf("line %d is %d squares wide, expected %d", i+1, len(line), len(slopeMap[0]))
+		}
+	}
+	return slopeMap, nil
+}
 
+// runSlopes runs every slope over the same parsed map and returns the results in slope order
+// With a legend the counter must be a TerrainCounter, and each result also carries its terrain and cost.
+func runSlopes(counter SlopeCounter, slopes []Slope, topology Topology, legend Legend) []SlopeResult {
 	var wg sync.WaitGroup
 	treeChannel := make(chan SlopeResult, len(slopes))
 
 	wg.Add(len(slopes))
 
-	for _, slope := range slopes {
-		go runSlope(slopeMap, slope, wrapWidth, finishLine, treeChannel, &wg)
+	for i, slope := range slopes {
+		go runSlope(counter, i, slope, topology, legend, treeChannel, &wg)
 	}
 
 	go func() {
@@ -141,37 +419,521 @@ func main() {
 		close(treeChannel)
 	}()
 
-	total := 1
+	// results arrive in whatever order the goroutines finish, so put them back in slope order
+	results := make([]SlopeResult, len(slopes))
 	for result := range treeChannel {
-		fmt.Printf("Slope: %d down, %d right: %d trees\n", result.slope.down, result.slope.right, result.trees)
-		total *= result.trees
+		results[result.index] = result
 	}
+	return results
+}
 
-	fmt.Printf("Total trees: %d\n", total)
+// rankSlopes orders results by fewest trees, then shortest path, then the slope itself
+func rankSlopes(results []SlopeResult) []SlopeResult {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.trees != b.trees {
+			return a.trees < b.trees
+		}
+		if a.steps != b.steps {
+			return a.steps < b.steps
+		}
+		if a.slope.down != b.slope.down {
+			return a.slope.down < b.slope.down
+		}
+		return a.slope.right < b.slope.right
+	})
+	return results
+}
+
+func slopesInRange(rightRange string, downRange string) ([]Slope, error) {
+	rightLo, rightHi, err := parseRange(rightRange)
+	if err != nil {
+		return nil, err
+	}
+	downLo, downHi, err := parseRange(downRange)
+	if err != nil {
+		return nil, err
+	}
+
+	var slopes []Slope
+	for down := downLo; down <= downHi; down++ {
+		for right := rightLo; right <= rightHi; right++ {
+			if right != 0 || down != 0 {
+				slopes = append(slopes, Slope{right, down})
+			}
+		}
+	}
+	return slopes, nil
+}
+
+func parseRange(s string) (int, int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("range %q should be lo:hi", s)
+	}
+	lo, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("range %q: bad lower bound", s)
+	}
+	hi, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("range %q: bad upper bound", s)
+	}
+	if lo > hi {
+		return 0, 0, fmt.Errorf("range %q is empty", s)
+	}
+	return lo, hi, nil
 }
 
-func runSlope(slopeMap Map, slope Slope, wrapWidth int, finishLine int,
+func runSlope(counter SlopeCounter, index int, slope Slope, topology Topology, legend Legend,
 	treeChannel chan SlopeResult, wg *sync.WaitGroup) {
 	defer wg.Done()
+	if legend == nil {
+		trees, steps := counter.countTrees(slope, topology)
+		treeChannel <- SlopeResult{index, slope, trees, steps, 0, nil}
+		return
+	}
 
+	terrain, steps := counter.(TerrainCounter).countTerrain(slope, topology)
+	var cost float64
+	for char, count := range terrain {
+		cost += legend[char].Cost * float64(count)
+	}
+	treeChannel <- SlopeResult{index, slope, terrain['#'], steps, cost, terrain}
+}
+
+func (m Map) countTrees(slope Slope, topology Topology) (int, int) {
 	trees := 0
-	loc := MapPosition{0, 0}
-	for {
-		loc = getNextPosition(loc, slope, wrapWidth)
-		if loc.row >= finishLine {
+	steps := walkSlope(len(m[0]), len(m), slope, topology, func(loc MapPosition) {
+		if m.treeAtPosition(loc) {
+			trees++
+		}
+	})
+	return trees, steps
+}
+
+func (m Map) countTerrain(slope Slope, topology Topology) (map[byte]int, int) {
+	terrain := make(map[byte]int)
+	steps := walkSlope(len(m[0]), len(m), slope, topology, func(loc MapPosition) {
+		terrain[m[loc.row][loc.col]]++
+	})
+	return terrain, steps
+}
+
+// walkSlope follows a slope under a topology, calling visit for every square it lands on, and
+// returns the number of steps taken. Runs start in the top-left corner like the puzzle, except that
+// slopes going up start on the bottom row and slopes going left start in the rightmost column.
+func walkSlope(width int, height int, slope Slope, topology Topology, visit func(MapPosition)) int {
+	maxSteps := topology.maxSteps
+	if maxSteps <= 0 {
+		maxSteps = width * height
+	}
+
+	pos := MapPosition{0, 0}
+	if slope.down < 0 {
+		pos.row = height - 1
+	}
+	if slope.right < 0 {
+		pos.col = width - 1
+	}
+	steps := 0
+	for steps < maxSteps {
+		pos = MapPosition{pos.row + slope.down, pos.col + slope.right}
+		loc, ok := topology.locate(pos, width, height)
+		if !ok {
 			break
 		}
-		if slopeMap.treeAtPosition(loc) {
-			trees++
+		steps++
+		visit(loc)
+	}
+	return steps
+}
+
+var topologies = map[string]func(MapPosition, int, int) (MapPosition, bool){
+	"wrap": func(pos MapPosition, width int, height int) (MapPosition, bool) {
+		return MapPosition{pos.row, mod(pos.col, width)}, pos.row >= 0 && pos.row < height
+	},
+	"none": func(pos MapPosition, width int, height int) (MapPosition, bool) {
+		return pos, pos.row >= 0 && pos.row < height && pos.col >= 0 && pos.col < width
+	},
+	"torus": func(pos MapPosition, width int, height int) (MapPosition, bool) {
+		return MapPosition{mod(pos.row, height), mod(pos.col, width)}, true
+	},
+	"mirror": func(pos MapPosition, width int, height int) (MapPosition, bool) {
+		col := mod(pos.col, width)
+		// every other tile, counting left and right from the original, is flipped
+		if mod(floorDiv(pos.col, width), 2) == 1 {
+			col = width - 1 - col
+		}
+		return MapPosition{pos.row, col}, pos.row >= 0 && pos.row < height
+	},
+}
+
+func mod(a int, b int) int {
+	return (a%b + b) % b
+}
+
+func floorDiv(a int, b int) int {
+	return (a - mod(a, b)) / b
+}
+
+// readBitMap builds a BitMap a line at a time, so the text of the map is never held in memory
+func readBitMap(r io.Reader) (*BitMap, error) {
+	m := &BitMap{}
+	// blank lines are only allowed at the end, so they're held back until another row turns up
+	blanks := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			blanks++
+			continue
+		}
+		if blanks > 0 {
+			return nil, fmt.Errorf("line %d is 0 squares wide, expected %d", m.height+1, m.width)
+		}
+		if m.height == 0 {
+			m.width = len(line)
+			m.cols = make([][]uint64, m.width)
+		}
+		if len(line) != m.width || m.width == 0 {
+			return nil, fmt.Errorf("line %d is %d squares wide, expected %d", m.height+1, len(line), m.width)
+		}
+		word, bit := m.height/64, uint(m.height%64)
+		for col, square := range line {
+			if bit == 0 {
+				m.cols[col] = append(m.cols[col], 0)
+			}
+			switch square {
+			case '#':
+				m.cols[col][word] |= 1 << bit
+			case '.':
+			default:
+				return nil, fmt.Errorf("line %d column %d: unexpected %q", m.height+1, col+1, square)
+			}
 		}
+		m.height++
 	}
-	treeChannel <- SlopeResult{slope, trees}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.height == 0 {
+		return nil, errors.New("Map is empty")
+	}
+	return m, nil
+}
+
+func (m *BitMap) treeAtPosition(pos MapPosition) bool {
+	return m.cols[pos.col][pos.row/64]>>uint(pos.row%64)&1 == 1
+}
+
+// countTrees walks slopes step by step unless they're plain downhill runs on the wrapping map.
+// Those it groups by the column each step lands in. Step k lands in column
+// k*right mod width, which repeats every width/gcd(right, width) steps, so each column is visited
+// at rows forming an arithmetic progression. Narrow strides (a wide slope whose right step shares
+// factors with the width, or right 0) are counted a word at a time with popcount.
+func (m *BitMap) countTrees(slope Slope, topology Topology) (int, int) {
+	steps := 0
+	if slope.down > 0 {
+		steps = (m.height - 1) / slope.down
+	}
+	if topology.name != "wrap" || slope.down <= 0 || slope.right < 0 || (topology.maxSteps > 0 && topology.maxSteps < steps) {
+		trees := 0
+		steps := walkSlope(m.width, m.height, slope, topology, func(loc MapPosition) {
+			if m.treeAtPosition(loc) {
+				trees++
+			}
+		})
+		return trees, steps
+	}
+
+	right := ((slope.right % m.width) + m.width) % m.width
+	period := m.width / gcd(right, m.width)
+	stride := period * slope.down
+
+	trees := 0
+	for j := 1; j <= period && j <= steps; j++ {
+		col := m.cols[(j*right)%m.width]
+		start := j * slope.down
+		if stride >= 64 {
+			for row := start; row < m.height; row += stride {
+				trees += int(col[row/64] >> uint(row%64) & 1)
+			}
+			continue
+		}
+		for word := start / 64; word < len(col); word++ {
+			base := word * 64
+			first := (start - base) % stride
+			if first < 0 {
+				first += stride
+			}
+			mask := strideMasks[stride] << uint(first)
+			if base < start {
+				mask &= ^uint64(0) << uint(start-base)
+			}
+			trees += bits.OnesCount64(col[word] & mask)
+		}
+	}
+	return trees, steps
+}
+
+// strideMasks[s] has every s-th bit set, starting from bit 0
+var strideMasks = func() [64]uint64 {
+	var masks [64]uint64
+	for s := 1; s < 64; s++ {
+		for b := 0; b < 64; b += s {
+			masks[s] |= 1 << uint(b)
+		}
+	}
+	return masks
+}()
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// findFewestTrees finds the route from the top-left square to past the bottom row that hits the
+// fewest trees. Every move goes down at least one row, so the best cost of each square only depends
+// on rows above it and the map can be solved top to bottom. Columns wrap, but the returned path
+// keeps counting them so the moves can be read back off it.
+func findFewestTrees(slopeMap Map, moves []Slope) (int, []MapPosition, bool) {
+	wrapWidth := len(slopeMap[0])
+	finishLine := len(slopeMap)
+
+	const unreachable = -1
+	trees := make([][]int, finishLine)
+	from := make([][]int, finishLine)
+	for row := range trees {
+		trees[row] = make([]int, wrapWidth)
+		from[row] = make([]int, wrapWidth)
+		for col := range trees[row] {
+			trees[row][col] = unreachable
+		}
+	}
+	trees[0][0] = 0
+
+	best, bestEnd := unreachable, MapPosition{}
+	for row := 0; row < finishLine; row++ {
+		for col := 0; col < wrapWidth; col++ {
+			if trees[row][col] == unreachable {
+				continue
+			}
+			for i, move := range moves {
+				next := getNextPosition(MapPosition{row, col}, move, wrapWidth)
+				if next.row >= finishLine {
+					if best == unreachable || trees[row][col] < best {
+						best, bestEnd = trees[row][col], MapPosition{row, col}
+					}
+					continue
+				}
+				cost := trees[row][col]
+				if slopeMap.treeAtPosition(next) {
+					cost++
+				}
+				if trees[next.row][next.col] == unreachable || cost < trees[next.row][next.col] {
+					trees[next.row][next.col] = cost
+					from[next.row][next.col] = i
+				}
+			}
+		}
+	}
+	if best == unreachable {
+		return 0, nil, false
+	}
+
+	var reversed []Slope
+	for loc := bestEnd; loc.row > 0 || loc.col > 0; {
+		move := moves[from[loc.row][loc.col]]
+		reversed = append(reversed, move)
+		loc = MapPosition{loc.row - move.down, ((loc.col-move.right)%wrapWidth + wrapWidth) % wrapWidth}
+	}
+	path := []MapPosition{{0, 0}}
+	for i := len(reversed) - 1; i >= 0; i-- {
+		last := path[len(path)-1]
+		path = append(path, MapPosition{last.row + reversed[i].down, last.col + reversed[i].right})
+	}
+	return best, path, true
+}
+
+// routeMarkers holds the open and tree markers for each slope in text mode
+var routeMarkers = [][2]byte{{'O', 'X'}, {'o', 'x'}}
+
+// ansiColours cycles through red, green, yellow, blue, magenta and cyan
+var ansiColours = []int{31, 32, 33, 34, 35, 36}
+
+// renderRoutes draws the map tiled far enough to hold every route, with the squares each slope
+// visits marked the way the puzzle text does. Routes follow walkSlope under the topology, so they
+// start and stop where slope runs do.
+func renderRoutes(w io.Writer, slopeMap Map, slopes []Slope, topology Topology, ansi bool) {
+	wrapWidth := len(slopeMap[0])
+	finishLine := len(slopeMap)
+
+	visits := make(map[MapPosition][]int)
+	firstTile, lastTile := 0, 0
+	for i, slope := range slopes {
+		// columns here keep counting past the edges, so the route runs across the tiles. A torus
+		// wraps rows as well, so its routes are drawn folded onto a single tile instead.
+		var pos MapPosition
+		tracked := topology
+		tracked.locate = func(next MapPosition, width int, height int) (MapPosition, bool) {
+			pos = next
+			return topology.locate(next, width, height)
+		}
+		walkSlope(wrapWidth, finishLine, slope, tracked, func(loc MapPosition) {
+			if topology.name != "torus" {
+				loc.col = pos.col
+			}
+			visits[loc] = append(visits[loc], i)
+			if tile := floorDiv(loc.col, wrapWidth); tile < firstTile {
+				firstTile = tile
+			} else if tile > lastTile {
+				lastTile = tile
+			}
+		})
+	}
+
+	for row := 0; row < finishLine; row++ {
+		line := make([]byte, 0, (lastTile-firstTile+1)*wrapWidth)
+		for col := firstTile * wrapWidth; col < (lastTile+1)*wrapWidth; col++ {
+			// tiles are drawn the way the topology sees them, so mirrored ones come out flipped
+			loc, ok := topology.locate(MapPosition{row, col}, wrapWidth, finishLine)
+			if !ok {
+				loc = MapPosition{row, mod(col, wrapWidth)}
+			}
+			square := slopeMap[loc.row][loc.col]
+			visitors := visits[MapPosition{row, col}]
+			switch {
+			case len(visitors) == 0:
+				line = append(line, square)
+			case len(visitors) > 1 && ansi:
+				line = append(line, "\x1b[1m*\x1b[0m"...)
+			case len(visitors) > 1:
+				line = append(line, '*')
+			case ansi:
+				marker := "O"
+				if square == '#' {
+					marker = "X"
+				}
+				line = append(line, fmt.Sprintf("\x1b[%dm%s\x1b[0m", ansiColours[visitors[0]%len(ansiColours)], marker)...)
+			default:
+				line = append(line, routeMarker(visitors[0], square == '#'))
+			}
+		}
+		fmt.Fprintf(w, "%s\n", line)
+	}
+}
+
+func routeMarker(slope int, tree bool) byte {
+	var markers [2]byte
+	if slope < len(routeMarkers) {
+		markers = routeMarkers[slope]
+	} else {
+		letter := byte('A' + (slope-len(routeMarkers))%26)
+		markers = [2]byte{letter, letter + 'a' - 'A'}
+	}
+	if tree {
+		return markers[1]
+	}
+	return markers[0]
 }
 
 func getNextPosition(start MapPosition, step Slope, wrap int) MapPosition {
-	return MapPosition{start.row + step.down, (start.col + step.right) % wrap}
+	return MapPosition{start.row + step.down, mod(start.col+step.right, wrap)}
 }
 
 func (m Map) treeAtPosition(pos MapPosition) bool {
 	return rune(m[pos.row][pos.col]) == '#'
 }
+
+func readSlopeConfig(path string) (SlopeList, error) {
+	input, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var slopes SlopeList
+	scanner := bufio.NewScanner(strings.NewReader(string(input)))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if err := slopes.Set(text); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+	}
+	return slopes, nil
+}
+
+func parseSlope(s string) (Slope, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Slope{}, fmt.Errorf("slope %q should be right,down", s)
+	}
+	right, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return Slope{}, fmt.Errorf("slope %q: bad right step", s)
+	}
+	down, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return Slope{}, fmt.Errorf("slope %q: bad down step", s)
+	}
+	if right == 0 && down == 0 {
+		return Slope{}, fmt.Errorf("slope %q doesn't move", s)
+	}
+	return Slope{right, down}, nil
+}
+
+func (sl *SlopeList) String() string {
+	var parts []string
+	for _, slope := range *sl {
+		parts = append(parts, fmt.Sprintf("%d,%d", slope.right, slope.down))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (sl *SlopeList) Set(s string) error {
+	slope, err := parseSlope(s)
+	if err != nil {
+		return err
+	}
+	*sl = append(*sl, slope)
+	return nil
+}
+
+func (ml *MoveList) String() string {
+	var parts []string
+	for _, move := range *ml {
+		parts = append(parts, fmt.Sprintf("%d,%d", move.right, move.down))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (ml *MoveList) Set(s string) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("move %q should be right,down", s)
+	}
+	rightLo, rightHi, err := parseRange(parts[0])
+	if err != nil {
+		rightLo, err = strconv.Atoi(parts[0])
+		rightHi = rightLo
+	}
+	if err != nil {
+		return fmt.Errorf("move %q: bad right step", s)
+	}
+	down, err := strconv.Atoi(parts[1])
+	if err != nil || down < 1 {
+		return fmt.Errorf("move %q must go down at least 1", s)
+	}
+	for right := rightLo; right <= rightHi; right++ {
+		*ml = append(*ml, Slope{right, down})
+	}
+	return nil
+}
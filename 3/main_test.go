@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// generateMap makes a map with roughly one tree in four squares
+func generateMap(rng *rand.Rand, width int, rows int) string {
+	var generated strings.Builder
+	for row := 0; row < rows; row++ {
+		for col := 0; col < width; col++ {
+			if rng.Intn(4) == 0 {
+				generated.WriteByte('#')
+			} else {
+				generated.WriteByte('.')
+			}
+		}
+		generated.WriteByte('\n')
+	}
+	return generated.String()
+}
+
+func readBothMaps(t testing.TB, text string) (Map, *BitMap) {
+	stringMap, err := readMap(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitMap, err := readBitMap(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stringMap, bitMap
+}
+
+func TestBitMapMatchesMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		stringMap, bitMap := readBothMaps(t, generateMap(rng, 1+rng.Intn(70), 1+rng.Intn(200)))
+		slope := Slope{rng.Intn(21) - 10, rng.Intn(9) - 4}
+		name := []string{"wrap", "none", "torus", "mirror"}[rng.Intn(4)]
+		topology := Topology{name, topologies[name], 0}
+		if rng.Intn(4) == 0 {
+			topology.maxSteps = rng.Intn(100)
+		}
+
+		stringTrees, stringSteps := stringMap.countTrees(slope, topology)
+		bitTrees, bitSteps := bitMap.countTrees(slope, topology)
+		if stringTrees != bitTrees || stringSteps != bitSteps {
+			t.Fatalf("%dx%d map, slope %d,%d, %s topology, max steps %d: string map found %d trees in %d steps, bitset %d in %d",
+				bitMap.width, bitMap.height, slope.right, slope.down, name, topology.maxSteps,
+				stringTrees, stringSteps, bitTrees, bitSteps)
+		}
+	}
+}
+
+// benchSlopes are the puzzle's slopes plus a wide one that stays in a single column
+var benchSlopes = []Slope{{1, 1}, {3, 1}, {5, 1}, {7, 1}, {1, 2}, {31, 1}}
+
+func benchmarkCounter(b *testing.B, counter func(Map, *BitMap) SlopeCounter) {
+	wrap := Topology{"wrap", topologies["wrap"], 0}
+	stringMap, bitMap := readBothMaps(b, generateMap(rand.New(rand.NewSource(1)), 31, 100000))
+	for _, slope := range benchSlopes {
+		b.Run(fmt.Sprintf("slope %d,%d", slope.right, slope.down), func(b *testing.B) {
+			c := counter(stringMap, bitMap)
+			for n := 0; n < b.N; n++ {
+				c.countTrees(slope, wrap)
+			}
+		})
+	}
+}
+
+func BenchmarkStringMap(b *testing.B) {
+	benchmarkCounter(b, func(m Map, _ *BitMap) SlopeCounter { return m })
+}
+
+func BenchmarkBitMap(b *testing.B) {
+	benchmarkCounter(b, func(_ Map, m *BitMap) SlopeCounter { return m })
+}
+
+func TestReadMapErrors(t *testing.T) {
+	cases := []struct {
+		name, text, err string
+	}{
+		{"ragged", "..#\n.#\n#..\n", "line 2 is 2 squares wide, expected 3"},
+		{"wider row", "..#\n.#.#\n", "line 2 is 4 squares wide, expected 3"},
+		{"blank line inside", "..#\n\n.#.\n", "line 2 is 0 squares wide, expected 3"},
+		{"blank first line", "\n..#\n", "line 1 is 0 squares wide, expected 0"},
+		{"only blank lines", "\n\n", "Map is empty"},
+		{"empty", "", "Map is empty"},
+	}
+	for _, c := range cases {
+		if _, err := readMap(strings.NewReader(c.text)); err == nil || err.Error() != c.err {
+			t.Errorf("%s: readMap gave error %v, want %q", c.name, err, c.err)
+		}
+		if _, err := readBitMap(strings.NewReader(c.text)); err == nil || err.Error() != c.err {
+			t.Errorf("%s: readBitMap gave error %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+func TestReadMapTrailingBlankLines(t *testing.T) {
+	wrap := Topology{"wrap", topologies["wrap"], 0}
+	for _, text := range []string{"..#\n.#.\n\n", "..#\n.#.\n\n\n", "..#\n.#.\n", "..#\n.#."} {
+		stringMap, bitMap := readBothMaps(t, text)
+		if len(stringMap) != 2 || bitMap.height != 2 || bitMap.width != 3 {
+			t.Errorf("%q: read %d rows and %dx%d bits, want 2 rows of 3", text, len(stringMap), bitMap.width, bitMap.height)
+			continue
+		}
+		stringTrees, _ := stringMap.countTrees(Slope{1, 1}, wrap)
+		bitTrees, _ := bitMap.countTrees(Slope{1, 1}, wrap)
+		if stringTrees != 1 || bitTrees != 1 {
+			t.Errorf("%q: slope 1,1 hit %d trees on the string map and %d on the bitset, want 1", text, stringTrees, bitTrees)
+		}
+	}
+}
@@ -129,43 +129,184 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 )
 
-//type Passport map[string]string
+// Passport is a decoded passport. The puzzle's fields are parsed into their types, nil when the
+// passport doesn't have them, and any other keys are kept as they were in Extra. Conflicts lists
+// keys the batch gave more than once under the "error" duplicate policy.
+type Passport struct {
+	Line           int
+	BirthYear      *Year
+	IssueYear      *Year
+	ExpirationYear *Year
+	Height         *Height
+	HairColor      *string
+	EyeColor       *string
+	ID             *string
+	CountryID      *string
+	Extra          map[string]string
+	Conflicts      []string
+}
+
+// Year keeps the raw text alongside the parsed year, so values that aren't numbers can still be reported
+type Year struct {
+	Raw    string
+	Value  int
+	Parsed bool
+}
+
+// Height is a number usually followed by a unit, like 183cm; Parsed is false when the raw text
+// doesn't start with digits or has more than a unit after them
+type Height struct {
+	Raw    string
+	Value  int
+	Unit   string
+	Parsed bool
+}
+
+// ParseError points at the line and token in a batch file that couldn't be read as a key:value pair
+type ParseError struct {
+	line  int
+	token string
+	msg   string
+}
+
+// Field is a passport value the way the schema's rules see it: the raw text, and for numbers and
+// measurements the decoded number and unit
+type Field struct {
+	Raw    string
+	Number int
+	Unit   string
+	Parsed bool
+}
+
 type PassportResult struct {
-	passport    sync.Map
+	passport    *Passport
 	valid       bool
 	validStrict bool
+	missing     []string
+	invalid     []FieldError
+	conflicts   []string
+}
+
+type FieldError struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	Rule  string `json:"rule"`
 }
 
+type PassportReport struct {
+	Line        int          `json:"line"`
+	Valid       bool         `json:"valid"`
+	ValidStrict bool         `json:"validStrict"`
+	Missing     []string     `json:"missing,omitempty"`
+	Invalid     []FieldError `json:"invalid,omitempty"`
+	Conflicts   []string     `json:"conflicts,omitempty"`
+}
+
+// Schema lists the passport fields; a passport is valid when every required field is present
+// and strictly valid when every present field with a type also passes its rule
+type Schema struct {
+	Fields []FieldRule `json:"fields"`
+}
+
+// FieldRule checks one field. Type int takes a whole number from Min to Max, with exactly Digits
+// digits when that's set, unit a number followed by one of Units with that unit's range (like 150cm
+// or 59in), regex a value matching Pattern and enum exactly one of Values. An empty Type accepts
+// any value.
+type FieldRule struct {
+	Field    string           `json:"field"`
+	Required bool             `json:"required"`
+	Type     string           `json:"type,omitempty"`
+	Min      int              `json:"min,omitempty"`
+	Max      int              `json:"max,omitempty"`
+	Digits   int              `json:"digits,omitempty"`
+	Pattern  string           `json:"pattern,omitempty"`
+	Values   []string         `json:"values,omitempty"`
+	Units    map[string]Range `json:"units,omitempty"`
+	pattern  *regexp.Regexp
+}
+
+type Range struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+const defaultSchema = `{
+	"fields": [
+		{"field": "byr", "required": true, "type": "int", "min": 1920, "max": 2002, "digits": 4},
+		{"field": "iyr", "required": true, "type": "int", "min": 2010, "max": 2020, "digits": 4},
+		{"field": "eyr", "required": true, "type": "int", "min": 2020, "max": 2030, "digits": 4},
+		{"field": "hgt", "required": true, "type": "unit", "units": {
+			"cm": {"min": 150, "max": 193},
+			"in": {"min": 59, "max": 76}
+		}},
+		{"field": "hcl", "required": true, "type": "regex", "pattern": "^#[0-9a-f]{6}$"},
+		{"field": "ecl", "required": true, "type": "enum", "values": ["amb", "blu", "brn", "gry", "grn", "hzl", "oth"]},
+		{"field": "pid", "required": true, "type": "regex", "pattern": "^[0-9]{9}$"},
+		{"field": "cid", "required": false}
+	]
+}`
+
+var measurement = regexp.MustCompile("^([0-9]+)([a-z]*)$")
+
 func main() {
 
-	input, err := ioutil.ReadFile("input.txt")
+	schemaPath := flag.String("schema", "", "JSON passport schema (default: the puzzle's rules)")
+	report := flag.String("report", "", "print why each passport passed or failed, as text or json, instead of counts")
+	inputPath := flag.String("input", "input.txt", "batch file of passports")
+	duplicates := flag.String("duplicates", "error", "what to do with a key repeated in a passport: error, first or last")
+	flag.Parse()
+
+	switch *duplicates {
+	case "error", "first", "last":
+	default:
+		panic(fmt.Errorf("Unknown duplicate key policy: %s", *duplicates))
+	}
+
+	if *report != "" && *report != "text" && *report != "json" {
+		panic(fmt.Errorf("Unknown report format: %s", *report))
+	}
+
+	schemaJSON := []byte(defaultSchema)
+	if *schemaPath != "" {
+		var err error
+		schemaJSON, err = ioutil.ReadFile(*schemaPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+	schema, err := parseSchema(schemaJSON)
 	if err != nil {
 		panic(err)
 	}
 
-	var passports []sync.Map
-	scanner := bufio.NewScanner(strings.NewReader(string(input)))
-	for {
-		passport, eof := scanPassport(scanner)
-		passports = append(passports, passport)
-		if eof {
-			break
-		}
+	input, err := os.Open(*inputPath)
+	if err != nil {
+		panic(err)
+	}
+	passports, parseErrors := parseBatch(input, *duplicates)
+	input.Close()
+	for _, err := range parseErrors {
+		fmt.Fprintln(os.Stderr, err)
 	}
 
 	var wg sync.WaitGroup
 	passportChannel := make(chan PassportResult, len(passports))
 	wg.Add(len(passports))
 	for _, passport := range passports {
-		go check(passportChannel, passport, &wg)
+		go check(passportChannel, passport, schema, &wg)
 	}
 
 	go func() {
@@ -173,6 +314,16 @@ func main() {
 		close(passportChannel)
 	}()
 
+	if *report != "" {
+		var reports []PassportReport
+		for result := range passportChannel {
+			reports = append(reports, PassportReport{result.passport.Line, result.valid, result.validStrict, result.missing, result.invalid, result.conflicts})
+		}
+		sort.Slice(reports, func(i, j int) bool { return reports[i].Line < reports[j].Line })
+		printReport(reports, *report)
+		return
+	}
+
 	count := 0
 	valid := 0
 	strict := 0
@@ -189,140 +340,296 @@ func main() {
 	fmt.Printf("Count: %d, Total valid: %d, Total strictly valid: %d\n", count, valid, strict)
 }
 
-func check(prc chan PassportResult, passport sync.Map, wg *sync.WaitGroup) {
-	defer wg.Done()
-	type valueValidation func(string) bool
-	type fieldReqs struct {
-		field     string
-		validator valueValidation
+func parseSchema(data []byte) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("Bad schema: %v", err)
 	}
-
-	// this got bloated...
-	requiredFields := []fieldReqs{
-		{"byr", func(s string) bool {
-			i, err := strconv.Atoi(s)
-			if err != nil {
-				return false
+	for i, rule := range schema.Fields {
+		switch rule.Type {
+		case "", "int", "enum":
+		case "unit":
+			if len(rule.Units) == 0 {
+				return nil, fmt.Errorf("Schema field %s: unit rule needs units", rule.Field)
 			}
-			return i >= 1920 && i <= 2020
-		}},
-		{"iyr", func(s string) bool {
-			i, err := strconv.Atoi(s)
+		case "regex":
+			pattern, err := regexp.Compile(rule.Pattern)
 			if err != nil {
-				return false
+				return nil, fmt.Errorf("Schema field %s: %v", rule.Field, err)
 			}
-			return i >= 2010 && i <= 2020
-		}},
-		{"eyr", func(s string) bool {
-			i, err := strconv.Atoi(s)
-			if err != nil {
-				return false
-			}
-			return i >= 2020 && i <= 2030
-		}},
-		{"hgt", func(s string) bool {
-			r := regexp.MustCompile("^[0-9]+(cm|in)$")
-			p := r.FindStringSubmatch(s)
-			if len(p) != 2 {
-				return false
-			}
-			i, err := strconv.Atoi(p[0][:len(p[0])-len(p[1])])
-			if err != nil {
-				return false
-			}
-			if p[1] == "in" {
-				return i >= 59 && i <= 76
+			schema.Fields[i].pattern = pattern
+		default:
+			return nil, fmt.Errorf("Schema field %s: unknown type %q", rule.Field, rule.Type)
+		}
+	}
+	return &schema, nil
+}
 
-			} else if p[1] == "cm" {
-				return i >= 150 && i <= 193
-			} else {
-				return false
+func (r FieldRule) validate(f Field) bool {
+	switch r.Type {
+	case "int":
+		if r.Digits > 0 && len(f.Raw) != r.Digits {
+			return false
+		}
+		return f.Parsed && f.Unit == "" && f.Number >= r.Min && f.Number <= r.Max
+	case "unit":
+		bounds, ok := r.Units[f.Unit]
+		return f.Parsed && ok && f.Number >= bounds.Min && f.Number <= bounds.Max
+	case "regex":
+		return r.pattern.MatchString(f.Raw)
+	case "enum":
+		for _, value := range r.Values {
+			if f.Raw == value {
+				return true
 			}
+		}
+		return false
+	}
+	return true
+}
 
-		}},
-		{"hcl", func(s string) bool {
-			matched, err := regexp.MatchString("^#[0-9a-f]{6}$", s)
-			if !matched || err != nil {
-				return false
-			}
-			return true
-		}},
-		{"ecl", func(s string) bool {
-			matched, err := regexp.MatchString("^(amb|blu|brn|gry|grn|hzl|oth)$", s)
-			if !matched || err != nil {
-				return false
-			}
-			return true
-		}},
-		{"pid", func(s string) bool {
-			matched, err := regexp.MatchString("^[0-9]{9}$", s)
-			if !matched || err != nil {
-				return false
-			}
-			return true
-		}},
-		//		{"cid", func(s string) bool { return true }},
+func (r FieldRule) String() string {
+	switch r.Type {
+	case "int":
+		if r.Digits > 0 {
+			return fmt.Sprintf("int %d-%d, %d digits", r.Min, r.Max, r.Digits)
+		}
+		return fmt.Sprintf("int %d-%d", r.Min, r.Max)
+	case "unit":
+		var units []string
+		for unit := range r.Units {
+			units = append(units, unit)
+		}
+		sort.Strings(units)
+		for i, unit := range units {
+			units[i] = fmt.Sprintf("%d-%d%s", r.Units[unit].Min, r.Units[unit].Max, unit)
+		}
+		return "unit " + strings.Join(units, " or ")
+	case "regex":
+		return "regex " + r.Pattern
+	case "enum":
+		return "enum " + strings.Join(r.Values, "|")
 	}
+	return "any"
+}
 
-	validStrict := true
+func check(prc chan PassportResult, passport *Passport, schema *Schema, wg *sync.WaitGroup) {
+	defer wg.Done()
+	prc <- evaluate(passport, schema)
+}
 
-	for _, req := range requiredFields {
-		valInt, ok := passport.Load(req.field)
+func evaluate(passport *Passport, schema *Schema) PassportResult {
+	result := PassportResult{passport: passport, conflicts: passport.Conflicts}
+	for _, rule := range schema.Fields {
+		field, ok := passport.field(rule.Field)
 		if !ok {
-			prc <- PassportResult{passport, false, false}
-			return
+			if rule.Required {
+				result.missing = append(result.missing, rule.Field)
+			}
+			continue
+		}
+		if !rule.validate(field) {
+			result.invalid = append(result.invalid, FieldError{rule.Field, field.Raw, rule.String()})
 		}
-		validStrict = validStrict && req.validator(valInt.(string))
-		/*		if !validStrict {
-					panic(valInt.(string))
-				}
-		*/
 	}
 
-	prc <- PassportResult{passport, true, validStrict}
+	// a passport missing fields isn't strictly valid either, whatever its other values are, and
+	// one with conflicting values can't be trusted to have any of them
+	result.valid = len(result.missing) == 0 && len(result.conflicts) == 0
+	result.validStrict = result.valid && len(result.invalid) == 0
+	return result
+}
+
+func printReport(reports []PassportReport, format string) {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			panic(err)
+		}
+		return
+	}
 
+	for _, report := range reports {
+		status := "invalid"
+		if report.ValidStrict {
+			status = "valid"
+		} else if report.Valid {
+			status = "has all fields, invalid values"
+		}
+		fmt.Printf("Passport at line %d: %s\n", report.Line, status)
+		for _, field := range report.Missing {
+			fmt.Printf("  missing %s\n", field)
+		}
+		for _, field := range report.Conflicts {
+			fmt.Printf("  conflicting values for %s\n", field)
+		}
+		for _, field := range report.Invalid {
+			fmt.Printf("  invalid %s %q: breaks %s\n", field.Field, field.Value, field.Rule)
+		}
+	}
 }
 
-func scanPassport(scanner *bufio.Scanner) (sync.Map, bool) {
-	var passport sync.Map
-	var eof bool
-	var err error
-	for {
-		eof = !scanner.Scan()
-		if eof {
-			break
+// parseBatch reads passports separated by blank lines, with key:value pairs split by any whitespace.
+// Bad pairs and duplicate keys are collected as errors rather than stopping the batch. Duplicates
+// keep the first value under "first" and the last under "last"; under "error" they're reported
+// and the passport lists the key in Conflicts, which makes it invalid.
+func parseBatch(r io.Reader, duplicates string) ([]*Passport, []error) {
+	var passports []*Passport
+	var errs []error
+	var fields map[string]string
+	var conflicts []string
+	start := 0
+	flush := func() {
+		if fields != nil {
+			passport := decodePassport(start, fields)
+			passport.Conflicts = conflicts
+			passports = append(passports, &passport)
 		}
-		line := scanner.Text()
-		if strings.Trim(line, " ") == "" {
-			break
+		fields = nil
+		conflicts = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) == 0 {
+			flush()
+			continue
 		}
-		passport, err = buildPassport(passport, parsePassportLine(line))
-		if err != nil {
-			panic(err)
+		if fields == nil {
+			fields = make(map[string]string)
+			start = lineNum
+		}
+		for _, token := range tokens {
+			sep := strings.Index(token, ":")
+			if sep <= 0 {
+				errs = append(errs, &ParseError{lineNum, token, "expected key:value"})
+				continue
+			}
+			key, value := token[:sep], token[sep+1:]
+			if _, ok := fields[key]; ok {
+				switch duplicates {
+				case "error":
+					errs = append(errs, &ParseError{lineNum, token, fmt.Sprintf("duplicate key %s in passport from line %d", key, start)})
+					if !containsKey(conflicts, key) {
+						conflicts = append(conflicts, key)
+					}
+					continue
+				case "first":
+					continue
+				}
+			}
+			fields[key] = value
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return passports, errs
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
 		}
 	}
-	return passport, eof
+	return false
 }
 
-func buildPassport(passport sync.Map, data sync.Map) (sync.Map, error) {
-	data.Range(func(key, value interface{}) bool {
-		_, ok := passport.Load(key)
-		if ok {
-			panic(fmt.Errorf("Duplicate key in passport: %s", key))
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, %q: %s", e.line, e.token, e.msg)
+}
+
+// decodePassport types the puzzle's fields, leaving anything it doesn't know in Extra
+func decodePassport(line int, fields map[string]string) Passport {
+	passport := Passport{Line: line, Extra: make(map[string]string)}
+	for key, value := range fields {
+		value := value
+		switch key {
+		case "byr":
+			passport.BirthYear = parseYear(value)
+		case "iyr":
+			passport.IssueYear = parseYear(value)
+		case "eyr":
+			passport.ExpirationYear = parseYear(value)
+		case "hgt":
+			passport.Height = parseHeight(value)
+		case "hcl":
+			passport.HairColor = &value
+		case "ecl":
+			passport.EyeColor = &value
+		case "pid":
+			passport.ID = &value
+		case "cid":
+			passport.CountryID = &value
+		default:
+			passport.Extra[key] = value
 		}
-		passport.Store(key, value)
-		return true
-	})
+	}
+	return passport
+}
+
+// parseYear only takes plain digits, like every other number a rule reads, so +2002 isn't a year
+func parseYear(s string) *Year {
+	f := parseField(s)
+	return &Year{s, f.Number, f.Parsed && f.Unit == ""}
+}
+
+func parseHeight(s string) *Height {
+	f := parseField(s)
+	return &Height{s, f.Number, f.Unit, f.Parsed}
+}
 
-	return passport, nil
+// parseField reads a number with an optional unit after it, which is all any rule needs decoded
+func parseField(s string) Field {
+	p := measurement.FindStringSubmatch(s)
+	if p == nil {
+		return Field{Raw: s}
+	}
+	number, err := strconv.Atoi(p[1])
+	if err != nil {
+		return Field{Raw: s}
+	}
+	return Field{s, number, p[2], true}
 }
 
-func parsePassportLine(line string) sync.Map {
-	var p sync.Map
-	attribs := strings.Split(line, " ")
-	for _, attribStr := range attribs {
-		attrib := strings.Split(attribStr, ":")
-		p.Store(attrib[0], attrib[1])
+// field gives a passport value to the schema, from the typed fields where the passport has them
+func (p *Passport) field(name string) (Field, bool) {
+	var text *string
+	var year *Year
+	switch name {
+	case "byr":
+		year = p.BirthYear
+	case "iyr":
+		year = p.IssueYear
+	case "eyr":
+		year = p.ExpirationYear
+	case "hgt":
+		if p.Height == nil {
+			return Field{}, false
+		}
+		return Field{p.Height.Raw, p.Height.Value, p.Height.Unit, p.Height.Parsed}, true
+	case "hcl":
+		text = p.HairColor
+	case "ecl":
+		text = p.EyeColor
+	case "pid":
+		text = p.ID
+	case "cid":
+		text = p.CountryID
+	default:
+		value, ok := p.Extra[name]
+		return parseField(value), ok
+	}
+	if year != nil {
+		return Field{Raw: year.Raw, Number: year.Value, Parsed: year.Parsed}, true
+	}
+	if text == nil {
+		return Field{}, false
 	}
-	return p
+	return parseField(*text), true
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func puzzleSchema(t *testing.T) *Schema {
+	schema, err := parseSchema([]byte(defaultSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestFieldRules(t *testing.T) {
+	cases := []struct {
+		field, value string
+		valid        bool
+	}{
+		// the examples listed in part two of the puzzle
+		{"byr", "2002", true},
+		{"byr", "2003", false},
+		{"hgt", "60in", true},
+		{"hgt", "190cm", true},
+		{"hgt", "190in", false},
+		{"hgt", "190", false},
+		{"hcl", "#123abc", true},
+		{"hcl", "#123abz", false},
+		{"hcl", "123abc", false},
+		{"ecl", "brn", true},
+		{"ecl", "wat", false},
+		{"pid", "000000001", true},
+		{"pid", "0123456789", false},
+
+		// the bounds of every rule
+		{"byr", "1919", false},
+		{"byr", "1920", true},
+		{"byr", "19x0", false},
+		// years are exactly four digits, with no sign or padding
+		{"byr", "+2002", false},
+		{"byr", "02002", false},
+		{"byr", "-2002", false},
+		{"byr", "0200", false},
+		{"iyr", "+2015", false},
+		{"iyr", "02015", false},
+		{"eyr", "02025", false},
+		{"eyr", "-2025", false},
+
+		{"iyr", "2009", false},
+		{"iyr", "2010", true},
+		{"iyr", "2020", true},
+		{"iyr", "2021", false},
+		{"iyr", "", false},
+		{"eyr", "2019", false},
+		{"eyr", "2020", true},
+		{"eyr", "2030", true},
+		{"eyr", "2031", false},
+		{"hgt", "149cm", false},
+		{"hgt", "150cm", true},
+		{"hgt", "193cm", true},
+		{"hgt", "194cm", false},
+		{"hgt", "58in", false},
+		{"hgt", "59in", true},
+		{"hgt", "76in", true},
+		{"hgt", "77in", false},
+		{"hgt", "170mm", false},
+		{"hgt", "cm", false},
+		{"hcl", "#123ABC", false},
+		{"hcl", "#123abcd", false},
+		{"ecl", "amb", true},
+		{"ecl", "oth", true},
+		{"ecl", "brnbrn", false},
+		{"pid", "00000001", false},
+		{"pid", "00000000a", false},
+		{"cid", "100", true},
+		{"cid", "anything", true},
+		{"cid", "", true},
+	}
+
+	schema := puzzleSchema(t)
+	rules := make(map[string]FieldRule)
+	for _, rule := range schema.Fields {
+		rules[rule.Field] = rule
+	}
+	for _, c := range cases {
+		rule, ok := rules[c.field]
+		if !ok {
+			t.Errorf("%s: no rule for field", c.field)
+			continue
+		}
+		passport := decodePassport(1, map[string]string{c.field: c.value})
+		field, ok := passport.field(c.field)
+		if !ok {
+			t.Errorf("%s %q: decoded passport lost the field", c.field, c.value)
+			continue
+		}
+		if rule.validate(field) != c.valid {
+			t.Errorf("%s %q: want valid %t under %s", c.field, c.value, c.valid, rule)
+		}
+	}
+}
+
+func TestBatches(t *testing.T) {
+	cases := []struct {
+		name          string
+		batch         string
+		valid, strict int
+	}{
+		{"part one", `ecl:gry pid:860033327 eyr:2020 hcl:#fffffd
+byr:1937 iyr:2017 cid:147 hgt:183cm
+
+iyr:2013 ecl:amb cid:350 eyr:2023 pid:028048884
+hcl:#cfa07d byr:1929
+
+hcl:#ae17e1 iyr:2013
+eyr:2024
+ecl:brn pid:760753108 byr:1931
+hgt:179cm
+
+hcl:#cfa07d eyr:2025 pid:166559648
+iyr:2011 ecl:brn hgt:59in
+`, 2, 2},
+		{"part two invalid", `eyr:1972 cid:100
+hcl:#18171d ecl:amb hgt:170 pid:186cm iyr:2018 byr:1926
+
+iyr:2019
+hcl:#602927 eyr:1967 hgt:170cm
+ecl:grn pid:012533040 byr:1946
+
+hcl:dab227 iyr:2012
+ecl:brn hgt:182cm pid:021572410 eyr:2020 byr:1992 cid:277
+
+hgt:59cm ecl:zzz
+eyr:2038 hcl:74454a iyr:2023
+pid:3556412378 byr:2007
+`, 4, 0},
+		{"part two valid", `pid:087499704 hgt:74in ecl:grn iyr:2012 eyr:2030 byr:1980
+hcl:#623a2f
+
+eyr:2029 ecl:blu cid:129 byr:1989
+iyr:2014 pid:896056539 hcl:#a97842 hgt:165cm
+
+hcl:#888785
+hgt:164cm byr:2001 iyr:2015 cid:88
+pid:545766238 ecl:hzl
+eyr:2022
+
+iyr:2010 hgt:158cm hcl:#b6652a ecl:blu byr:1944 eyr:2021 pid:093154719
+`, 4, 4},
+	}
+
+	schema := puzzleSchema(t)
+	for _, c := range cases {
+		passports, errs := parseBatch(strings.NewReader(c.batch), "error")
+		for _, err := range errs {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		valid, strict := 0, 0
+		for _, passport := range passports {
+			result := evaluate(passport, schema)
+			if result.valid {
+				valid++
+			}
+			if result.validStrict {
+				strict++
+			}
+		}
+		if len(passports) != 4 || valid != c.valid || strict != c.strict {
+			t.Errorf("%s: got %d passports, %d valid and %d strictly valid, want 4, %d and %d",
+				c.name, len(passports), valid, strict, c.valid, c.strict)
+		}
+	}
+}